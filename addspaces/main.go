@@ -1,7 +1,8 @@
 package main
 
 import (
-	"bytes"
+	"bufio"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -12,11 +13,21 @@ import (
 )
 
 func main() {
-	if len(os.Args) != 2 {
-		fmt.Fprintln(os.Stderr, "Usage: addspaces <model file>")
+	var nbest, batchSize int
+	flag.IntVar(&nbest, "nbest", 0, "print the `K` best segmentations with their scores")
+	flag.IntVar(&batchSize, "batch", 64, "maximum number of lines to process together "+
+		"for models which support batches")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: addspaces [flags] <model file>")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
 		os.Exit(1)
 	}
-	modelData, err := ioutil.ReadFile(os.Args[1])
+	modelData, err := ioutil.ReadFile(flag.Arg(0))
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "Failed to read model:", err)
 		os.Exit(1)
@@ -31,6 +42,30 @@ func main() {
 		fmt.Fprintf(os.Stderr, "Unexpected deserialized type: %T\n", model)
 		os.Exit(1)
 	}
+	if nbest > 0 {
+		nbestFielder, ok := fielder.(spacesplice.NBestFielder)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Model does not support n-best output: %T\n", model)
+			os.Exit(1)
+		}
+		for str := range inputStream() {
+			for _, seg := range nbestFielder.NBestFields(str, nbest) {
+				fmt.Printf("%.3f\t%.3f\t%s\n", seg.LogProb, seg.Confidence,
+					strings.Join(seg.Fields, " "))
+			}
+			fmt.Println()
+		}
+		return
+	}
+	if batchFielder, ok := fielder.(spacesplice.BatchFielder); ok && batchSize > 1 {
+		lines := inputStream()
+		for batch := range inputBatches(lines, batchSize) {
+			for _, fields := range batchFielder.BatchFields(batch) {
+				fmt.Println(strings.Join(fields, " "))
+			}
+		}
+		return
+	}
 	for str := range inputStream() {
 		fields := fielder.Fields(str)
 		fmt.Println(strings.Join(fields, " "))
@@ -38,25 +73,47 @@ func main() {
 }
 
 func inputStream() <-chan string {
-	res := make(chan string)
+	res := make(chan string, 1024)
 	go func() {
-		var buf [1]byte
-		var stringBuf bytes.Buffer
+		reader := bufio.NewReader(os.Stdin)
 		for {
-			if n, err := os.Stdin.Read(buf[:]); err != nil {
-				if stringBuf.Len() > 0 {
-					res <- stringBuf.String()
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				if line != "" {
+					res <- line
 				}
 				close(res)
 				return
-			} else if n > 0 {
-				if buf[0] == '\n' {
-					res <- stringBuf.String()
-					stringBuf.Reset()
-				} else {
-					stringBuf.WriteByte(buf[0])
+			}
+			res <- strings.TrimSuffix(line, "\n")
+		}
+	}()
+	return res
+}
+
+// inputBatches groups lines into batches of up to size
+// lines.
+// A batch is sent as soon as no more lines are ready, so
+// interactive input is not delayed.
+func inputBatches(lines <-chan string, size int) <-chan []string {
+	res := make(chan []string)
+	go func() {
+		defer close(res)
+		for line := range lines {
+			batch := []string{line}
+		FillBatch:
+			for len(batch) < size {
+				select {
+				case line, ok := <-lines:
+					if !ok {
+						break FillBatch
+					}
+					batch = append(batch, line)
+				default:
+					break FillBatch
 				}
 			}
+			res <- batch
 		}
 	}()
 	return res
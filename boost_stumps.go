@@ -34,7 +34,7 @@ func TrainBoostStumps(corpusDir string) (*BoostStumps, error) {
 
 	var samples boostSampleList
 	ReadSamples(corpusDir, func(sampleBody []byte) {
-		fields := strings.Fields(string(sampleBody))
+		fields := Tokenize(string(sampleBody))
 		boundaries := map[int]bool{}
 		var joined bytes.Buffer
 		var idx int
@@ -122,7 +122,7 @@ func (b *BoostStumps) Fields(text string) []string {
 			res = append(res, field.String())
 		}
 	}
-	return res
+	return joinPunctuation(res)
 }
 
 // SerializerType returns the unique ID used to
@@ -1,44 +1,165 @@
 package spacesplice
 
 import (
+	"encoding/json"
+	"errors"
+	"math"
 	"sort"
 	"strings"
+	"sync"
+	"unicode/utf8"
 )
 
-const dictionaryMaxWord = 20
+const dictionaryUnknownCost = 2
 
-// A Dictionary is a Fielder which operates by picking
-// the longest possible dictionary word.
+// DictionaryMode determines how a Dictionary splits
+// text into words.
+type DictionaryMode string
+
+const (
+	// DictionaryGreedy repeatedly picks the longest
+	// dictionary word at the start of the text.
+	DictionaryGreedy DictionaryMode = "greedy"
+
+	// DictionaryFewestWords picks the segmentation with
+	// the fewest words, where every character outside of
+	// a dictionary word counts as dictionaryUnknownCost
+	// words.
+	DictionaryFewestWords DictionaryMode = "fewest-words"
+
+	// DictionaryFewestUnknown picks the segmentation with
+	// the fewest characters outside of dictionary words,
+	// breaking ties by the number of words.
+	DictionaryFewestUnknown DictionaryMode = "fewest-unknown"
+
+	// DictionaryUnigram picks the segmentation with the
+	// greatest likelihood under a unigram model of the
+	// word counts.
+	// Every character outside of a dictionary word is as
+	// unlikely as dictionaryUnknownCost words which were
+	// seen once.
+	DictionaryUnigram DictionaryMode = "unigram"
+)
+
+// A Dictionary is a Fielder which splits text into
+// words from a list of known words.
+//
+// The indices used to look up words are built when the
+// Dictionary is first used, so Words and Counts should
+// not be changed after that.
 type Dictionary struct {
 	// Words is an alphabetically-sorted list of words.
 	Words []string
+
+	// Counts stores the number of times each word in
+	// Words was seen.
+	// If it is empty, every word has a count of 1.
+	Counts []int
+
+	// Mode is the algorithm used to choose words.
+	// The empty mode is equivalent to DictionaryGreedy.
+	Mode DictionaryMode
+
+	// MaxEdits is the greatest number of edits (insertions,
+	// deletions, substitutions, and transpositions) with
+	// which a piece of text may match a dictionary word.
+	// It is 0 if matches must be exact.
+	// Fuzzy matching is not used by DictionaryGreedy.
+	//
+	// It should be changed with SetMaxEdits, which builds
+	// the index used to find fuzzy matches.
+	MaxEdits int
+
+	// EditCost is the cost of each edit in a fuzzy match.
+	// For DictionaryUnigram, it is measured in words which
+	// were seen once, like the cost of unknown characters.
+	// For the other modes, it is measured in words.
+	EditCost float64
+
+	indicesOnce sync.Once
+	trie        *trie
+	total       int
+	deletes     *dictionaryDeletes
+}
+
+// NewDictionary creates a Dictionary from a map of words
+// to the number of times they were seen.
+func NewDictionary(counts map[string]int, mode DictionaryMode) *Dictionary {
+	res := &Dictionary{Mode: mode, EditCost: dictionaryDefaultEditCost}
+	for word := range counts {
+		res.Words = append(res.Words, word)
+	}
+	sort.Strings(res.Words)
+	for _, word := range res.Words {
+		res.Counts = append(res.Counts, counts[word])
+	}
+	res.buildIndices()
+	return res
 }
 
 // TrainDictionary trains a Dictionary by reading all
 // of the files in the given directory and extracting
 // their words.
-func TrainDictionary(corpusDir string) (*Dictionary, error) {
-	wordMap := map[string]bool{}
+//
+// If ignoreCase is true, the words are stored in lower
+// case.
+// Since a Dictionary also looks up the lower case form of
+// text which it does not contain, the words then match
+// regardless of case.
+func TrainDictionary(corpusDir string, mode DictionaryMode, ignoreCase bool) (*Dictionary, error) {
+	if !mode.valid() {
+		return nil, errors.New("unknown dictionary mode: " + string(mode))
+	}
+	counts := map[string]int{}
 	err := ReadSamples(corpusDir, func(sampleBody []byte) {
-		for _, field := range strings.Fields(string(sampleBody)) {
-			wordMap[field] = true
+		for _, field := range Tokenize(string(sampleBody)) {
+			if ignoreCase {
+				field = foldCase(field)
+			}
+			counts[field]++
 		}
 	})
 	if err != nil {
 		return nil, err
 	}
-	words := make([]string, 0, len(wordMap))
-	for word := range wordMap {
-		words = append(words, word)
-	}
-	sort.Strings(words)
-	return &Dictionary{Words: words}, nil
+	return NewDictionary(counts, mode), nil
 }
 
 // DeserializeDictionary deserializes a dictionary that
 // was serialized with Dictionary.Serialize().
+//
+// Dictionaries used to be serialized as plain word lists,
+// which are still supported and use DictionaryGreedy.
+// Words without counts are given a count of 1.
 func DeserializeDictionary(d []byte) (*Dictionary, error) {
-	return &Dictionary{Words: strings.Fields(string(d))}, nil
+	if len(d) > 0 && d[0] == '{' {
+		// Dictionaries from before fuzzy matching have no
+		// EditCost, so they get the default.
+		res := Dictionary{EditCost: dictionaryDefaultEditCost}
+		if err := json.Unmarshal(d, &res); err != nil {
+			return nil, err
+		}
+		if !res.Mode.valid() {
+			return nil, errors.New("unknown dictionary mode: " + string(res.Mode))
+		}
+		if !sort.StringsAreSorted(res.Words) {
+			return nil, errors.New("dictionary words are not sorted")
+		}
+		if len(res.Counts) != 0 && len(res.Counts) != len(res.Words) {
+			return nil, errors.New("mismatched dictionary counts")
+		}
+		if res.MaxEdits < 0 {
+			return nil, errors.New("invalid dictionary edit limit")
+		}
+		res.buildIndices()
+		res.SetMaxEdits(res.MaxEdits)
+		return &res, nil
+	}
+	counts := map[string]int{}
+	for _, word := range strings.Fields(string(d)) {
+		counts[word] = 1
+	}
+	return NewDictionary(counts, DictionaryGreedy), nil
 }
 
 // Contains returns true if x is in the dictionary.
@@ -50,23 +171,118 @@ func (d *Dictionary) Contains(x string) bool {
 	return d.Words[idx] == x
 }
 
+// Count returns the number of times x was seen, or 0 if
+// x is not in the dictionary.
+func (d *Dictionary) Count(x string) int {
+	d.buildIndices()
+	idx, ok := d.trie.lookup(x)
+	if !ok {
+		return 0
+	}
+	return d.count(idx)
+}
+
+// countFolded is like Count, but it also checks for x in
+// lower case if x itself is not in the dictionary.
+func (d *Dictionary) countFolded(x string) int {
+	if count := d.Count(x); count != 0 {
+		return count
+	}
+	return d.Count(foldCase(x))
+}
+
+func (d *Dictionary) count(idx int) int {
+	if len(d.Counts) == 0 {
+		return 1
+	}
+	return d.Counts[idx]
+}
+
+// containsFolded returns true if x is in the dictionary,
+// either as-is or in lower case.
+func (d *Dictionary) containsFolded(x string) bool {
+	d.buildIndices()
+	return d.trie.contains(x) || d.trie.contains(foldCase(x))
+}
+
+// prefixes calls f with every prefix of str which is in
+// the dictionary, either as-is or in lower case, from
+// shortest to longest.
+func (d *Dictionary) prefixes(str string, f func(word string)) {
+	var lengths []int
+	addLength := func(word string) {
+		lengths = append(lengths, len(word))
+	}
+	d.buildIndices()
+	d.trie.prefixes(str, addLength)
+	if folded := foldCase(str); folded != str {
+		d.trie.prefixes(folded, addLength)
+		sort.Ints(lengths)
+	}
+	for i, l := range lengths {
+		if i == 0 || l != lengths[i-1] {
+			f(str[:l])
+		}
+	}
+}
+
+// SetMaxEdits sets MaxEdits and rebuilds the index used
+// to find fuzzy matches.
+// It should not be called while the Dictionary is in use.
+func (d *Dictionary) SetMaxEdits(maxEdits int) {
+	d.MaxEdits = maxEdits
+	d.deletes = newDictionaryDeletes(d.Words, maxEdits)
+}
+
+// buildIndices builds the trie of the dictionary's words
+// and counts the total number of words, unless this has
+// already been done.
+// It may be called from multiple Goroutines at once.
+func (d *Dictionary) buildIndices() {
+	d.indicesOnce.Do(func() {
+		d.trie = newTrie(d.Words)
+		for i := range d.Words {
+			d.total += d.count(i)
+		}
+	})
+}
+
 // Fields uses the dictionary to split the text into
 // fields (i.e. words).
 func (d *Dictionary) Fields(text string) []string {
+	if d.Mode != DictionaryGreedy && d.Mode != "" {
+		return d.NBestFields(text, 1)[0].Fields
+	}
 	var res []string
 	for _, part := range strings.Fields(text) {
 		for len(part) > 0 {
-			longestWord := part[:1]
-			for l := 2; l <= maxWordLen && l <= len(part); l++ {
-				if d.Contains(part[:l]) {
-					longestWord = part[:l]
+			_, size := utf8.DecodeRuneInString(part)
+			longestWord := part[:size]
+			d.prefixes(part, func(word string) {
+				if len(word) > len(longestWord) {
+					longestWord = word
 				}
-			}
+			})
 			res = append(res, longestWord)
 			part = part[len(longestWord):]
 		}
 	}
-	return res
+	return joinPunctuation(res)
+}
+
+// NBestFields returns the n best segmentations of the
+// text.
+//
+// Segmentations are ranked by the cost which is minimized
+// by d.Mode, or by the DictionaryFewestWords cost for
+// DictionaryGreedy.
+// The LogProb of each segmentation is its negated cost,
+// or its log-likelihood for DictionaryUnigram.
+func (d *Dictionary) NBestFields(text string, n int) []Segmentation {
+	return nbestFields(text, n, func(part string) []Segmentation {
+		res, _ := d.nbestPart(part, n)
+		return res
+	})
 }
 
 // SerializerType returns the unique ID used to
@@ -78,5 +294,79 @@ func (d *Dictionary) SerializerType() string {
 
 // Serialize serializes the Dictionary.
 func (d *Dictionary) Serialize() ([]byte, error) {
-	return []byte(strings.Join(d.Words, "\n")), nil
+	return json.Marshal(d)
+}
+
+// nbestPart finds the n best segmentations of a part of
+// the text with no whitespace.
+// It also returns the fuzzy matches of any fields which
+// are not in the dictionary.
+func (d *Dictionary) nbestPart(part string, n int) ([]Segmentation, map[string]dictionaryMatch) {
+	matches := map[string]dictionaryMatch{}
+	candidates := d.candidates
+	if d.MaxEdits > 0 {
+		index := d.fuzzyIndex()
+		candidates = func(str string, f func(word string)) {
+			d.candidates(str, f)
+			d.fuzzyCandidates(index, str, func(word string, match dictionaryMatch) {
+				matches[word] = match
+				f(word)
+			})
+		}
+	}
+	return nbestSegmentations(part, n, candidates, d.scoreFunc(part, matches)), matches
+}
+
+// scoreFunc returns the function used to score the words
+// of a segmentation of part.
+func (d *Dictionary) scoreFunc(part string,
+	matches map[string]dictionaryMatch) func(previous, word string) float64 {
+	if d.Mode == DictionaryUnigram {
+		d.buildIndices()
+		logTotal := math.Log(math.Max(1, float64(d.total)))
+		return func(_, word string) float64 {
+			if count := d.countFolded(word); count > 0 {
+				return math.Log(float64(count)) - logTotal
+			} else if match, ok := matches[word]; ok {
+				count := math.Max(1, float64(d.Count(match.Word)))
+				return math.Log(count) - logTotal - d.EditCost*logTotal*float64(match.Edits)
+			}
+			return -dictionaryUnknownCost * logTotal
+		}
+	}
+	unknownCost := float64(dictionaryUnknownCost)
+	if d.Mode == DictionaryFewestUnknown {
+		// Every segmentation has fewer than len(part)+1
+		// words, so unknown characters always dominate.
+		unknownCost = float64(len(part) + 1)
+	}
+	return func(_, word string) float64 {
+		if d.containsFolded(word) {
+			return -1
+		} else if match, ok := matches[word]; ok {
+			return -1 - d.EditCost*float64(match.Edits)
+		}
+		return -unknownCost
+	}
+}
+
+// candidates enumerates the dictionary words at the start
+// of str, as well as its first character.
+func (d *Dictionary) candidates(str string, f func(word string)) {
+	_, size := utf8.DecodeRuneInString(str)
+	f(str[:size])
+	d.prefixes(str, func(word string) {
+		if len(word) > size {
+			f(word)
+		}
+	})
+}
+
+func (m DictionaryMode) valid() bool {
+	switch m {
+	case "", DictionaryGreedy, DictionaryFewestWords, DictionaryFewestUnknown,
+		DictionaryUnigram:
+		return true
+	}
+	return false
 }
@@ -0,0 +1,216 @@
+package spacesplice
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	dictionaryDefaultEditCost = 2
+
+	// dictionaryCharsPerEdit is the number of characters
+	// a piece of text must have for each edit in a fuzzy
+	// match, so that short words are not matched to
+	// unrelated text.
+	dictionaryCharsPerEdit = 4
+)
+
+// A Match is a field of text along with the dictionary
+// word it matched, which may be spelled differently.
+type Match struct {
+	Field string
+
+	// Word is the dictionary word, or "" if the field did
+	// not match any word.
+	Word string
+
+	// Edits is the edit distance between Field and Word.
+	Edits int
+}
+
+// Matches splits the text into fields like NBestFields,
+// and returns the dictionary word which each field was
+// matched to.
+// Unlike Fields, punctuation is not joined to the words
+// around it.
+func (d *Dictionary) Matches(text string) []Match {
+	var res []Match
+	for _, part := range strings.Fields(text) {
+		segs, fuzzy := d.nbestPart(part, 1)
+		for _, field := range segs[0].Fields {
+			match := Match{Field: field}
+			if fuzzyMatch, ok := fuzzy[field]; ok {
+				match.Word = fuzzyMatch.Word
+				match.Edits = fuzzyMatch.Edits
+			} else if d.Count(field) > 0 {
+				match.Word = field
+			} else if folded := foldCase(field); d.Count(folded) > 0 {
+				match.Word = folded
+			}
+			res = append(res, match)
+		}
+	}
+	return res
+}
+
+// dictionaryMatch is a fuzzy match of a piece of text.
+type dictionaryMatch struct {
+	Word  string
+	Edits int
+}
+
+// dictionaryDeletes is a SymSpell-style index which maps
+// every string that can be made by deleting up to
+// maxEdits characters from a word to the indices of the
+// words it came from.
+type dictionaryDeletes struct {
+	maxEdits int
+	maxLen   int
+	words    map[string][]int
+}
+
+// fuzzyCandidates calls f with every prefix of str which
+// is not in the dictionary but is within the allowed
+// number of edits of a dictionary word.
+func (d *Dictionary) fuzzyCandidates(index *dictionaryDeletes, str string,
+	f func(word string, match dictionaryMatch)) {
+	_, firstSize := utf8.DecodeRuneInString(str)
+	numRunes := 1
+	for l := firstSize + 1; l <= len(str) && l <= index.maxLen; l++ {
+		if l < len(str) && !utf8.RuneStart(str[l]) {
+			continue
+		}
+		numRunes++
+		word := str[:l]
+		maxEdits := numRunes / dictionaryCharsPerEdit
+		if maxEdits > d.MaxEdits {
+			maxEdits = d.MaxEdits
+		}
+		if maxEdits == 0 || d.containsFolded(word) {
+			continue
+		}
+		if match, ok := d.fuzzyMatch(index, word, maxEdits); ok {
+			f(word, match)
+		} else if folded := foldCase(word); folded != word {
+			if match, ok := d.fuzzyMatch(index, folded, maxEdits); ok {
+				f(word, match)
+			}
+		}
+	}
+}
+
+// fuzzyMatch finds the dictionary word with the fewest
+// edits from str, preferring more frequent words when
+// there is a tie.
+func (d *Dictionary) fuzzyMatch(index *dictionaryDeletes, str string,
+	maxEdits int) (dictionaryMatch, bool) {
+	strRunes := []rune(str)
+	bestIdx := -1
+	var bestEdits int
+	checked := map[int]bool{}
+	stringDeletes(str, maxEdits, func(deleted string) {
+		for _, idx := range index.words[deleted] {
+			if checked[idx] {
+				continue
+			}
+			checked[idx] = true
+			edits := editDistance(strRunes, []rune(d.Words[idx]))
+			if edits > maxEdits {
+				continue
+			}
+			if bestIdx < 0 || edits < bestEdits ||
+				(edits == bestEdits && d.count(idx) > d.count(bestIdx)) {
+				bestIdx, bestEdits = idx, edits
+			}
+		}
+	})
+	if bestIdx < 0 {
+		return dictionaryMatch{}, false
+	}
+	return dictionaryMatch{Word: d.Words[bestIdx], Edits: bestEdits}, true
+}
+
+// fuzzyIndex returns the deletes index for MaxEdits.
+//
+// If MaxEdits was changed without SetMaxEdits, a new
+// index is built but not stored, since the Dictionary
+// may be in use by other Goroutines.
+func (d *Dictionary) fuzzyIndex() *dictionaryDeletes {
+	if d.deletes != nil && d.deletes.maxEdits == d.MaxEdits {
+		return d.deletes
+	}
+	return newDictionaryDeletes(d.Words, d.MaxEdits)
+}
+
+// newDictionaryDeletes builds the deletes index for a
+// list of words.
+// It returns nil if maxEdits is 0.
+func newDictionaryDeletes(words []string, maxEdits int) *dictionaryDeletes {
+	if maxEdits == 0 {
+		return nil
+	}
+	res := &dictionaryDeletes{
+		maxEdits: maxEdits,
+		words:    map[string][]int{},
+	}
+	for i, word := range words {
+		if len(word)+maxEdits > res.maxLen {
+			res.maxLen = len(word) + maxEdits
+		}
+		stringDeletes(word, maxEdits, func(deleted string) {
+			res.words[deleted] = append(res.words[deleted], i)
+		})
+	}
+	return res
+}
+
+// stringDeletes calls f once with every string that can
+// be made by deleting up to maxEdits runes from str,
+// including str itself.
+func stringDeletes(str string, maxEdits int, f func(deleted string)) {
+	seen := map[string]bool{str: true}
+	level := []string{str}
+	f(str)
+	for i := 0; i < maxEdits; i++ {
+		var nextLevel []string
+		for _, s := range level {
+			for j, r := range s {
+				deleted := s[:j] + s[j+utf8.RuneLen(r):]
+				if !seen[deleted] {
+					seen[deleted] = true
+					nextLevel = append(nextLevel, deleted)
+					f(deleted)
+				}
+			}
+		}
+		level = nextLevel
+	}
+}
+
+// editDistance computes the optimal string alignment
+// distance between two strings, which counts
+// insertions, deletions, substitutions, and
+// transpositions of adjacent characters.
+func editDistance(s1, s2 []rune) int {
+	rows := make([][]int, len(s1)+1)
+	for i := range rows {
+		rows[i] = make([]int, len(s2)+1)
+		rows[i][0] = i
+	}
+	for j := range rows[0] {
+		rows[0][j] = j
+	}
+	for i := 1; i <= len(s1); i++ {
+		for j := 1; j <= len(s2); j++ {
+			cost := 1
+			if s1[i-1] == s2[j-1] {
+				cost = 0
+			}
+			rows[i][j] = minInt(rows[i-1][j]+1, minInt(rows[i][j-1]+1, rows[i-1][j-1]+cost))
+			if i > 1 && j > 1 && s1[i-1] == s2[j-2] && s1[i-2] == s2[j-1] {
+				rows[i][j] = minInt(rows[i][j], rows[i-2][j-2]+1)
+			}
+		}
+	}
+	return rows[len(s1)][len(s2)]
+}
@@ -0,0 +1,101 @@
+package spacesplice
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// dictionaryFiller is added to the test corpus to make
+// short words like "of" rare enough that skipping them
+// with fuzzy matches is tempting.
+var dictionaryFiller = strings.Repeat("The model reads text and writes words. ", 40)
+
+func TestDictionaryFields(t *testing.T) {
+	expected := []string{"The", "documentation", "consists", "of", "two", "parts."}
+	for _, mode := range []DictionaryMode{DictionaryFewestWords, DictionaryFewestUnknown,
+		DictionaryUnigram} {
+		d, err := TrainDictionary(testCorpusDir(t, dictionaryFiller), mode, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for maxEdits := 0; maxEdits <= 2; maxEdits++ {
+			d.SetMaxEdits(maxEdits)
+			actual := d.Fields("Thedocumentationconsistsoftwoparts.")
+			if !reflect.DeepEqual(actual, expected) {
+				t.Errorf("mode %s with %d edits: expected %q but got %q", mode, maxEdits,
+					expected, actual)
+			}
+		}
+	}
+}
+
+func TestDictionaryFuzzy(t *testing.T) {
+	d, err := TrainDictionary(testCorpusDir(t, dictionaryFiller), DictionaryUnigram, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.SetMaxEdits(1)
+	expected := []Match{
+		{Field: "the", Word: "the"},
+		{Field: "documnetation", Word: "documentation", Edits: 1},
+		{Field: "is", Word: "is"},
+		{Field: "short", Word: "short"},
+	}
+	if actual := d.Matches("thedocumnetationisshort"); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %v but got %v", expected, actual)
+	}
+}
+
+func TestDictionaryEditCost(t *testing.T) {
+	d := NewDictionary(map[string]int{"word": 1}, DictionaryUnigram)
+	if d.EditCost != dictionaryDefaultEditCost {
+		t.Errorf("NewDictionary: expected EditCost %v but got %v", dictionaryDefaultEditCost,
+			d.EditCost)
+	}
+	legacy := []byte(`{"Words":["word"],"Counts":[1],"Mode":"unigram"}`)
+	d, err := DeserializeDictionary(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.EditCost != dictionaryDefaultEditCost {
+		t.Errorf("DeserializeDictionary: expected EditCost %v but got %v",
+			dictionaryDefaultEditCost, d.EditCost)
+	}
+	if d.Count("word") != 1 {
+		t.Error("word missing from deserialized dictionary")
+	}
+}
+
+func TestDeserializeDictionaryErrors(t *testing.T) {
+	cases := map[string]string{
+		"truncated JSON": `{"Words":["a","b"],"Mode":"unigram"`,
+		"unsorted words": `{"Words":["b","a"],"Mode":"unigram"}`,
+		"unknown mode":   `{"Words":["a","b"],"Mode":"random"}`,
+		"bad counts":     `{"Words":["a","b"],"Counts":[1],"Mode":"unigram"}`,
+	}
+	for name, data := range cases {
+		if _, err := DeserializeDictionary([]byte(data)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestDictionaryLiteral(t *testing.T) {
+	expected := []string{"the", "cat", "sat"}
+	for _, mode := range []DictionaryMode{DictionaryGreedy, DictionaryFewestWords,
+		DictionaryUnigram} {
+		d := &Dictionary{Words: []string{"cat", "sat", "the"}, Mode: mode}
+		results := make(chan []string, 4)
+		for i := 0; i < cap(results); i++ {
+			go func() {
+				results <- d.Fields("thecatsat")
+			}()
+		}
+		for i := 0; i < cap(results); i++ {
+			if actual := <-results; !reflect.DeepEqual(actual, expected) {
+				t.Errorf("mode %s: expected %q but got %q", mode, expected, actual)
+			}
+		}
+	}
+}
@@ -2,99 +2,233 @@ package spacesplice
 
 import (
 	"bytes"
-	"encoding/gob"
+	"errors"
 	"log"
+	"math/rand"
+	"runtime"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/unixpickle/weakai/idtrees"
 )
 
 const (
-	forestSize         = 500
-	forestFeatureCount = 6
-	forestSampleCount  = 7000
-	forestFracCutoff   = 0.22
+	forestDefaultTrees   = 500
+	forestDefaultSamples = 7000
+	forestDefaultCutoff  = 0.22
+	forestDefaultHeldOut = 0.1
+
+	// forestBalancedCutoff is the cutoff for forests
+	// trained with balanced sampling, which corresponds
+	// to a boundary probability of 0.5.
+	forestBalancedCutoff = 1.0
+
+	// forestAttrFrac is the default fraction of the
+	// attributes used by each tree.
+	forestAttrFrac = 6.0 / 11
 )
 
 // Forest is a Splicer that uses a random forest to
 // insert spaces into a piece of text.
 type Forest struct {
-	forest idtrees.Forest
+	features *ForestFeatures
+	trees    *compiledForest
+
+	// cutoff is the smallest ratio between the boundary
+	// and non-boundary probabilities at which a field
+	// ends.
+	cutoff float64
+
+	// stats is nil for forests trained before statistics
+	// were computed.
+	stats *ForestStats
+}
+
+// forestData is the form in which a Forest was
+// serialized before its trees were compiled.
+type forestData struct {
+	Features *ForestFeatures
+	Trees    idtrees.Forest
+	Cutoff   float64
+	Stats    *ForestStats
+}
+
+// ForestConfig stores the settings used to train a
+// Forest.
+// Zero values select the defaults.
+type ForestConfig struct {
+	// Features configures the attributes of the text
+	// which the trees use.
+	// If it is nil, DefaultForestFeatures is used.
+	Features *ForestFeatures
+
+	// Seed seeds the random number generator used to
+	// select samples and attributes for each tree.
+	// Training with the same seed and corpus always
+	// produces the same forest.
+	Seed int64
+
+	// NumTrees is the number of trees in the forest.
+	NumTrees int
+
+	// NumSamples is the number of samples (i.e. bytes of
+	// text) used to train each tree.
+	NumSamples int
+
+	// NumAttrs is the number of attributes each tree may
+	// split on.
+	// By default, it is proportional to the number of
+	// attributes enabled by Features.
+	NumAttrs int
+
+	// Unbalanced disables balanced sampling.
+	//
+	// By default, each tree is trained on equal numbers of
+	// boundary and non-boundary samples, chosen without
+	// replacement, so the forest does not favor either
+	// class even though boundaries are much rarer in real
+	// text.
+	Unbalanced bool
+
+	// Cutoff is the smallest ratio between the boundary
+	// and non-boundary probabilities at which a field
+	// ends.
+	// If it is 0, balanced forests use a cutoff of 1,
+	// i.e. a boundary probability of 0.5, and unbalanced
+	// forests calibrate the cutoff to maximize the F1
+	// score of boundaries in held-out text.
+	Cutoff float64
+
+	// HeldOut is the fraction of each document which is
+	// held out of training to calibrate the cutoff of an
+	// unbalanced forest.
+	// It may only be set if the cutoff is calibrated.
+	HeldOut float64
 }
 
 // TrainForest trains a forest on a directory full
 // of sample text files.
-func TrainForest(corpusDir string) (*Forest, error) {
+// If config is nil, the default configuration is used.
+func TrainForest(corpusDir string, config *ForestConfig) (*Forest, error) {
+	if config == nil {
+		config = &ForestConfig{}
+	}
+	features := config.Features
+	if features == nil {
+		features = DefaultForestFeatures()
+	} else {
+		// The features are copied, since training sets the
+		// vocabulary of known words.
+		copied := *features
+		features = &copied
+	}
+	calibrate := config.Cutoff == 0 && config.Unbalanced
+	if config.HeldOut != 0 && !calibrate {
+		return nil, errors.New("held-out text is only used to calibrate the cutoff " +
+			"of an unbalanced forest")
+	}
+	heldOutFrac := config.HeldOut
+	if heldOutFrac == 0 {
+		heldOutFrac = forestDefaultHeldOut
+	}
+	if heldOutFrac < 0 || heldOutFrac >= 1 {
+		return nil, errors.New("held-out fraction must be in [0, 1)")
+	}
+
 	log.Println("Building samples...")
 
-	var samples []idtrees.Sample
+	var trainDocs, heldOutDocs [][]string
 	err := ReadSamples(corpusDir, func(sampleBody []byte) {
-		fields := strings.Fields(string(sampleBody))
-		boundaries := map[int]bool{}
-		var joined bytes.Buffer
-		var idx int
-		for _, f := range fields {
-			for i, x := range []byte(f) {
-				if i == len(f)-1 {
-					boundaries[idx] = true
-				}
-				joined.WriteByte(x)
-				idx++
-			}
-		}
-		joinedStr := joined.String()
-		for i := 0; i < joined.Len(); i++ {
-			samples = append(samples, &forestSample{
-				textDoc:    joinedStr,
-				index:      i,
-				endOfField: boundaries[i],
-			})
+		fields := Tokenize(string(sampleBody))
+		if !calibrate {
+			trainDocs = append(trainDocs, fields)
+			return
 		}
+		split := len(fields) - int(float64(len(fields))*heldOutFrac+0.5)
+		trainDocs = append(trainDocs, fields[:split])
+		heldOutDocs = append(heldOutDocs, fields[split:])
 	})
 	if err != nil {
 		return nil, err
 	}
+	if features.KnownWords {
+		features.trainVocab(trainDocs)
+	}
+	samples := forestSamples(features, trainDocs)
+	if len(samples) == 0 {
+		return nil, errors.New("no training samples")
+	}
 
 	log.Println("Creating forest...")
 
-	var allAttrs []idtrees.Attr
-	for i := -7; i <= 3; i++ {
-		allAttrs = append(allAttrs, i)
+	attrs := features.attrs()
+	forest, bags := buildForest(samples, attrs, config)
+	trees, err := compileForest(forest)
+	if err != nil {
+		return nil, err
+	}
+	res := &Forest{
+		features: features,
+		trees:    trees,
+		cutoff:   config.Cutoff,
+	}
+	if calibrate {
+		log.Println("Calibrating cutoff...")
+		var f1 float64
+		res.cutoff, f1 = res.calibrateCutoff(forestSamples(features, heldOutDocs))
+		log.Printf("Using cutoff %f (held-out F1 %f)", res.cutoff, f1)
+	} else if res.cutoff == 0 {
+		res.cutoff = forestBalancedCutoff
 	}
-	forest := idtrees.BuildForest(forestSize, samples, allAttrs, forestSampleCount,
-		forestFeatureCount, func(s []idtrees.Sample, a []idtrees.Attr) *idtrees.Tree {
-			return idtrees.ID3(s, a, 0)
-		})
-	return &Forest{forest: forest}, nil
+
+	log.Println("Computing out-of-bag statistics...")
+	res.stats = res.computeStats(samples, bags, attrs, config.Seed)
+	log.Printf("Out-of-bag error: %f", res.stats.OOBError)
+
+	return res, nil
 }
 
 // DeserializeForest deserializes a Forest which
 // was serialized with Forest.Serialize().
+//
+// Forests which were serialized with gob, before the
+// compact format was introduced, are also supported.
+// Their trees are compiled as they are loaded.
 func DeserializeForest(d []byte) (*Forest, error) {
-	buf := bytes.NewBuffer(d)
-	dec := gob.NewDecoder(buf)
-	var res idtrees.Forest
-	if err := dec.Decode(&res); err != nil {
+	var res *Forest
+	var err error
+	if bytes.HasPrefix(d, []byte(forestMagic)) {
+		res, err = decodeForest(d)
+	} else {
+		res, err = decodeForestGob(d)
+	}
+	if err != nil {
 		return nil, err
 	}
-	return &Forest{forest: res}, nil
+	if res.cutoff == 0 {
+		res.cutoff = forestDefaultCutoff
+	}
+	res.features.buildTrie()
+	return res, nil
 }
 
 // Fields uses the forest to split the spaceless text
 // into fields (i.e. words).
 func (f *Forest) Fields(text string) []string {
 	parts := strings.Fields(text)
+	docs := make([]*forestDoc, len(parts))
+	for i, part := range parts {
+		docs[i] = f.features.newDoc(part)
+	}
+	allBoundaries := f.classifyDocs(docs)
+
 	var res []string
-	for _, part := range parts {
+	for partIdx, part := range parts {
 		var field bytes.Buffer
 		for i := 0; i < len(part); i++ {
-			sample := &forestSample{
-				textDoc: part,
-				index:   i,
-			}
 			field.WriteByte(part[i])
-			probs := f.forest.Classify(sample)
-			if probs[true] >= probs[false]*forestFracCutoff {
+			if allBoundaries[partIdx][i] {
 				res = append(res, field.String())
 				field.Reset()
 			}
@@ -103,7 +237,31 @@ func (f *Forest) Fields(text string) []string {
 			res = append(res, field.String())
 		}
 	}
-	return res
+	return joinPunctuation(res)
+}
+
+// Features returns the feature configuration of the
+// forest.
+func (f *Forest) Features() *ForestFeatures {
+	return f.features
+}
+
+// NumTrees returns the number of trees in the forest.
+func (f *Forest) NumTrees() int {
+	return len(f.trees.roots)
+}
+
+// Cutoff returns the smallest ratio between the boundary
+// and non-boundary probabilities at which a field ends.
+func (f *Forest) Cutoff() float64 {
+	return f.cutoff
+}
+
+// Stats returns the statistics computed while training
+// the forest, or nil if the forest was trained before
+// statistics were computed.
+func (f *Forest) Stats() *ForestStats {
+	return f.stats
 }
 
 // SerializerType returns the unique ID used to
@@ -115,26 +273,266 @@ func (f *Forest) SerializerType() string {
 
 // Serialize serializes the random forest.
 func (f *Forest) Serialize() ([]byte, error) {
-	var res bytes.Buffer
-	enc := gob.NewEncoder(&res)
-	if err := enc.Encode(f.forest); err != nil {
-		return nil, err
+	return f.encode(), nil
+}
+
+func (f *Forest) isBoundary(boundary, inside float64) bool {
+	return boundary >= inside*f.cutoff
+}
+
+// calibrateCutoff finds the cutoff which maximizes the
+// F1 score of boundaries in the held-out samples.
+// If there are no held-out boundaries, the default
+// cutoff is used.
+func (f *Forest) calibrateCutoff(samples []idtrees.Sample) (cutoff, f1 float64) {
+	type scoredSample struct {
+		prob     float64
+		boundary bool
+	}
+	scored := make([]scoredSample, len(samples))
+	var numBoundaries int
+	parallelFor(len(samples), func(i int) {
+		boundary, inside := f.trees.classify(f.trees.values(samples[i]))
+		scored[i].boundary = samples[i].Class().(bool)
+		if total := boundary + inside; total > 0 {
+			scored[i].prob = boundary / total
+		}
+	})
+	for _, s := range scored {
+		if s.boundary {
+			numBoundaries++
+		}
+	}
+	if numBoundaries == 0 {
+		return forestDefaultCutoff, 0
 	}
-	return res.Bytes(), nil
+	sort.Slice(scored, func(i, j int) bool {
+		return scored[i].prob > scored[j].prob
+	})
+
+	// Try a threshold between every pair of distinct
+	// probabilities, predicting a boundary for every
+	// sample above it.
+	bestThreshold := 0.5
+	var truePos int
+	for i, s := range scored {
+		if s.boundary {
+			truePos++
+		}
+		if i+1 < len(scored) && scored[i+1].prob == s.prob {
+			continue
+		}
+		score := 2 * float64(truePos) / float64(i+1+numBoundaries)
+		if score > f1 {
+			f1 = score
+			bestThreshold = s.prob / 2
+			if i+1 < len(scored) {
+				bestThreshold += scored[i+1].prob / 2
+			}
+		}
+	}
+
+	// Convert the probability into a ratio of the
+	// boundary and non-boundary probabilities.
+	return bestThreshold / (1 - bestThreshold), f1
+}
+
+// classifyDocs decides whether a field ends at every
+// byte of every document, spreading the work across
+// GOMAXPROCS goroutines.
+func (f *Forest) classifyDocs(docs []*forestDoc) [][]bool {
+	res := make([][]bool, len(docs))
+	var samples []*forestSample
+	for i, doc := range docs {
+		res[i] = make([]bool, len(doc.text))
+		for j := range doc.text {
+			samples = append(samples, &forestSample{doc: doc, index: j})
+		}
+	}
+	boundaries := make([]bool, len(samples))
+	parallelFor(len(samples), func(i int) {
+		boundaries[i] = f.isBoundary(f.trees.classify(f.trees.values(samples[i])))
+	})
+	var idx int
+	for _, docBoundaries := range res {
+		idx += copy(docBoundaries, boundaries[idx:])
+	}
+	return res
+}
+
+// buildForest trains the trees of a forest concurrently.
+// It also returns the indices of the samples used to
+// train each tree.
+//
+// Each tree is trained on a random subset of the samples
+// and attributes, chosen without replacement by its own
+// random number generator so that the result does not
+// depend on the order in which trees are built.
+// Unless config.Unbalanced is set, half of each subset
+// is chosen from the boundary samples and half from the
+// other samples.
+func buildForest(samples []idtrees.Sample, attrs []idtrees.Attr,
+	config *ForestConfig) (idtrees.Forest, [][]int) {
+	numSamples := config.NumSamples
+	if numSamples == 0 {
+		numSamples = forestDefaultSamples
+	}
+	if numSamples > len(samples) {
+		numSamples = len(samples)
+	}
+	numAttrs := config.NumAttrs
+	if numAttrs == 0 {
+		numAttrs = int(forestAttrFrac*float64(len(attrs)) + 0.5)
+	}
+	if numAttrs > len(attrs) {
+		numAttrs = len(attrs)
+	}
+	numTrees := config.NumTrees
+	if numTrees == 0 {
+		numTrees = forestDefaultTrees
+	}
+
+	var classIndices [2][]int
+	for i, sample := range samples {
+		if sample.Class().(bool) {
+			classIndices[0] = append(classIndices[0], i)
+		} else {
+			classIndices[1] = append(classIndices[1], i)
+		}
+	}
+	balanced := !config.Unbalanced && len(classIndices[0]) > 0 && len(classIndices[1]) > 0
+
+	res := make(idtrees.Forest, numTrees)
+	bags := make([][]int, numTrees)
+	parallelFor(len(res), func(i int) {
+		gen := rand.New(rand.NewSource(config.Seed + int64(i)))
+		if balanced {
+			bags[i] = balancedSubset(gen, classIndices, numSamples)
+		} else {
+			bags[i] = randomSubset(gen, len(samples), numSamples)
+		}
+		treeSamples := make([]idtrees.Sample, len(bags[i]))
+		for j, idx := range bags[i] {
+			treeSamples[j] = samples[idx]
+		}
+		treeAttrs := make([]idtrees.Attr, numAttrs)
+		for j, idx := range gen.Perm(len(attrs))[:numAttrs] {
+			treeAttrs[j] = attrs[idx]
+		}
+		res[i] = idtrees.ID3(treeSamples, treeAttrs, 1)
+	})
+	return res, bags
+}
+
+// randomSubset picks k distinct random integers in the
+// range [0, n).
+func randomSubset(gen *rand.Rand, n, k int) []int {
+	// Sparse Fisher-Yates shuffle of the first k entries.
+	swapped := map[int]int{}
+	res := make([]int, k)
+	for i := range res {
+		j := gen.Intn(n-i) + i
+		jVal, ok := swapped[j]
+		if !ok {
+			jVal = j
+		}
+		iVal, ok := swapped[i]
+		if !ok {
+			iVal = i
+		}
+		res[i] = jVal
+		swapped[j] = iVal
+	}
+	return res
+}
+
+// balancedSubset picks k distinct indices, choosing an
+// equal number from each class when possible.
+// If one class is too small, all of its indices are
+// used and the rest are chosen from the other class.
+func balancedSubset(gen *rand.Rand, classIndices [2][]int, k int) []int {
+	var counts [2]int
+	counts[0] = minInt(k/2, len(classIndices[0]))
+	counts[1] = minInt(k-counts[0], len(classIndices[1]))
+	counts[0] = minInt(k-counts[1], len(classIndices[0]))
+	var res []int
+	for class, indices := range classIndices {
+		for _, idx := range randomSubset(gen, len(indices), counts[class]) {
+			res = append(res, indices[idx])
+		}
+	}
+	return res
+}
+
+// parallelFor calls f for every integer in [0, n),
+// using GOMAXPROCS goroutines.
+func parallelFor(n int, f func(i int)) {
+	indices := make(chan int, n)
+	for i := 0; i < n; i++ {
+		indices <- i
+	}
+	close(indices)
+
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.GOMAXPROCS(0); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range indices {
+				f(idx)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// forestSamples creates a sample for every byte of every
+// document, where each document is a list of fields.
+func forestSamples(features *ForestFeatures, docs [][]string) []idtrees.Sample {
+	var samples []idtrees.Sample
+	for _, fields := range docs {
+		boundaries := map[int]bool{}
+		var joined bytes.Buffer
+		var idx int
+		for _, f := range fields {
+			for i, x := range []byte(f) {
+				if i == len(f)-1 {
+					boundaries[idx] = true
+				}
+				joined.WriteByte(x)
+				idx++
+			}
+		}
+		doc := features.newDoc(joined.String())
+		for i := 0; i < joined.Len(); i++ {
+			samples = append(samples, &forestSample{
+				doc:        doc,
+				index:      i,
+				endOfField: boundaries[i],
+			})
+		}
+	}
+	return samples
 }
 
 type forestSample struct {
-	textDoc    string
+	doc        *forestDoc
 	index      int
 	endOfField bool
 }
 
 func (f *forestSample) Attr(a idtrees.Attr) idtrees.Val {
+	if attr, ok := a.(forestAttr); ok {
+		return f.doc.byteAttr(attr.Kind, f.index+attr.Offset)
+	}
+
+	// Forests from before features were configurable
+	// use byte offsets as attributes.
 	idx := a.(int) + f.index
-	if idx < 0 || idx >= len(f.textDoc) {
+	if idx < 0 || idx >= len(f.doc.text) {
 		return 0
 	}
-	return f.textDoc[idx]
+	return f.doc.text[idx]
 }
 
 func (f *forestSample) Class() idtrees.Class {
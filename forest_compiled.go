@@ -0,0 +1,223 @@
+package spacesplice
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/unixpickle/weakai/idtrees"
+)
+
+type compiledNodeKind uint8
+
+const (
+	compiledLeaf compiledNodeKind = iota
+	compiledNumSplit
+	compiledValSplit
+)
+
+// compiledForest stores the trees of a forest in flat
+// arrays of nodes.
+//
+// Every attribute value is converted to an int64, so a
+// byte is classified by computing each attribute once
+// and then following node indices through each tree.
+type compiledForest struct {
+	// attrs lists the attributes used by the trees.
+	// Nodes refer to attributes by their index in attrs.
+	attrs []idtrees.Attr
+
+	roots []int32
+	nodes []compiledNode
+	edges []compiledEdge
+}
+
+// compiledNode is a leaf or a branch of a compiled tree.
+// The children of a node always come after it.
+type compiledNode struct {
+	kind      compiledNodeKind
+	attr      int32
+	threshold int64
+
+	// For a numeric split, first and second are the
+	// children for values <= and > the threshold.
+	// For a value split, they are the range of the
+	// node's edges, which are sorted by value.
+	first  int32
+	second int32
+
+	// boundary and inside are the probabilities of the
+	// classes at a leaf.
+	// They are both 0 if the leaf was unreachable in the
+	// training data.
+	boundary float32
+	inside   float32
+}
+
+// compiledEdge is a branch of a value split.
+type compiledEdge struct {
+	val   int64
+	child int32
+}
+
+// compileForest converts the trees of a forest into a
+// compiledForest.
+func compileForest(forest idtrees.Forest) (*compiledForest, error) {
+	res := &compiledForest{}
+	attrIndices := map[idtrees.Attr]int32{}
+	for _, tree := range forest {
+		root, err := res.compileTree(tree, attrIndices)
+		if err != nil {
+			return nil, err
+		}
+		res.roots = append(res.roots, root)
+	}
+	return res, nil
+}
+
+func (c *compiledForest) compileTree(t *idtrees.Tree, attrIndices map[idtrees.Attr]int32) (int32, error) {
+	idx := int32(len(c.nodes))
+	c.nodes = append(c.nodes, compiledNode{})
+	if t.Classification != nil {
+		c.nodes[idx] = compiledNode{
+			kind:     compiledLeaf,
+			boundary: float32(t.Classification[true]),
+			inside:   float32(t.Classification[false]),
+		}
+		return idx, nil
+	}
+
+	attr, ok := attrIndices[t.Attr]
+	if !ok {
+		attr = int32(len(c.attrs))
+		attrIndices[t.Attr] = attr
+		c.attrs = append(c.attrs, t.Attr)
+	}
+
+	if t.NumSplit != nil {
+		threshold, ok := t.NumSplit.Threshold.(int64)
+		if !ok {
+			return 0, fmt.Errorf("unsupported threshold type: %T", t.NumSplit.Threshold)
+		}
+		lessEqual, err := c.compileTree(t.NumSplit.LessEqual, attrIndices)
+		if err != nil {
+			return 0, err
+		}
+		greater, err := c.compileTree(t.NumSplit.Greater, attrIndices)
+		if err != nil {
+			return 0, err
+		}
+		c.nodes[idx] = compiledNode{
+			kind:      compiledNumSplit,
+			attr:      attr,
+			threshold: threshold,
+			first:     lessEqual,
+			second:    greater,
+		}
+		return idx, nil
+	}
+
+	type branch struct {
+		val  int64
+		tree *idtrees.Tree
+	}
+	var branches []branch
+	for val, child := range t.ValSplit {
+		intVal, ok := compiledValue(val)
+		if !ok {
+			return 0, fmt.Errorf("unsupported attribute value type: %T", val)
+		}
+		branches = append(branches, branch{intVal, child})
+	}
+	sort.Slice(branches, func(i, j int) bool {
+		return branches[i].val < branches[j].val
+	})
+
+	// Reserve the edges before compiling the children so
+	// that the edges of this node are contiguous.
+	start := len(c.edges)
+	c.edges = append(c.edges, make([]compiledEdge, len(branches))...)
+	for i, b := range branches {
+		child, err := c.compileTree(b.tree, attrIndices)
+		if err != nil {
+			return 0, err
+		}
+		c.edges[start+i] = compiledEdge{val: b.val, child: child}
+	}
+	c.nodes[idx] = compiledNode{
+		kind:   compiledValSplit,
+		attr:   attr,
+		first:  int32(start),
+		second: int32(start + len(branches)),
+	}
+	return idx, nil
+}
+
+// values computes the value of every attribute used by
+// the trees.
+func (c *compiledForest) values(s idtrees.AttrMap) []int64 {
+	res := make([]int64, len(c.attrs))
+	for i, attr := range c.attrs {
+		res[i], _ = compiledValue(s.Attr(attr))
+	}
+	return res
+}
+
+// classify sums the class probabilities of every tree.
+func (c *compiledForest) classify(values []int64) (boundary, inside float64) {
+	for tree := range c.roots {
+		b, i := c.classifyTree(tree, values)
+		boundary += float64(b)
+		inside += float64(i)
+	}
+	return
+}
+
+// classifyTree computes the class probabilities of a
+// single tree.
+func (c *compiledForest) classifyTree(tree int, values []int64) (boundary, inside float32) {
+	node := &c.nodes[c.roots[tree]]
+	for {
+		switch node.kind {
+		case compiledLeaf:
+			return node.boundary, node.inside
+		case compiledNumSplit:
+			if values[node.attr] > node.threshold {
+				node = &c.nodes[node.second]
+			} else {
+				node = &c.nodes[node.first]
+			}
+		case compiledValSplit:
+			val := values[node.attr]
+			lo, hi := node.first, node.second
+			for lo < hi {
+				mid := (lo + hi) / 2
+				if c.edges[mid].val < val {
+					lo = mid + 1
+				} else {
+					hi = mid
+				}
+			}
+			if lo == node.second || c.edges[lo].val != val {
+				return 0, 0
+			}
+			node = &c.nodes[c.edges[lo].child]
+		}
+	}
+}
+
+// compiledValue converts an attribute value to an int64.
+//
+// Forests from before features were configurable use
+// an int 0 for bytes outside of the text, which must not
+// be confused with a uint8 0, so ints are negated.
+func compiledValue(val idtrees.Val) (int64, bool) {
+	switch val := val.(type) {
+	case uint8:
+		return int64(val), true
+	case int64:
+		return val, true
+	case int:
+		return -1 - int64(val), true
+	}
+	return 0, false
+}
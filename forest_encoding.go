@@ -0,0 +1,401 @@
+package spacesplice
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/unixpickle/weakai/idtrees"
+)
+
+const (
+	forestMagic   = "SSFR"
+	forestVersion = 1
+
+	forestFlagBytes       = 1
+	forestFlagClasses     = 2
+	forestFlagTransitions = 4
+	forestFlagKnownWords  = 8
+
+	// forestLegacyAttrKind marks an attribute which is a
+	// byte offset, as used by forests from before
+	// features were configurable.
+	forestLegacyAttrKind = 0xff
+)
+
+// encode serializes the forest in a compact binary
+// format.
+//
+// The format consists of a header, the features, the
+// cutoff, the statistics, the attributes used by the
+// trees, and the nodes of the compiled trees.
+// Integers are varints and leaf probabilities are
+// little-endian float32s.
+func (f *Forest) encode() []byte {
+	var buf bytes.Buffer
+	buf.WriteString(forestMagic)
+	writeUvarint(&buf, forestVersion)
+
+	features := f.features
+	var flags uint64
+	if features.Bytes {
+		flags |= forestFlagBytes
+	}
+	if features.Classes {
+		flags |= forestFlagClasses
+	}
+	if features.Transitions {
+		flags |= forestFlagTransitions
+	}
+	if features.KnownWords {
+		flags |= forestFlagKnownWords
+	}
+	writeUvarint(&buf, flags)
+	writeUvarint(&buf, uint64(features.Before))
+	writeUvarint(&buf, uint64(features.After))
+	writeUvarint(&buf, uint64(len(features.Vocab)))
+	for _, word := range features.Vocab {
+		writeString(&buf, word)
+	}
+
+	writeUvarint(&buf, math.Float64bits(f.cutoff))
+	if f.stats == nil {
+		writeUvarint(&buf, 0)
+	} else {
+		writeUvarint(&buf, 1)
+		writeUvarint(&buf, math.Float64bits(f.stats.OOBError))
+		names := make([]string, 0, len(f.stats.Importance))
+		for name := range f.stats.Importance {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		writeUvarint(&buf, uint64(len(names)))
+		for _, name := range names {
+			writeString(&buf, name)
+			writeUvarint(&buf, math.Float64bits(f.stats.Importance[name]))
+		}
+	}
+
+	trees := f.trees
+	writeUvarint(&buf, uint64(len(trees.attrs)))
+	for _, attr := range trees.attrs {
+		if fAttr, ok := attr.(forestAttr); ok {
+			writeUvarint(&buf, uint64(fAttr.Kind))
+			writeVarint(&buf, int64(fAttr.Offset))
+		} else {
+			writeUvarint(&buf, forestLegacyAttrKind)
+			writeVarint(&buf, int64(attr.(int)))
+		}
+	}
+
+	writeUvarint(&buf, uint64(len(trees.nodes)))
+	for _, node := range trees.nodes {
+		writeUvarint(&buf, uint64(node.kind))
+		switch node.kind {
+		case compiledLeaf:
+			var probs [8]byte
+			binary.LittleEndian.PutUint32(probs[:4], math.Float32bits(node.boundary))
+			binary.LittleEndian.PutUint32(probs[4:], math.Float32bits(node.inside))
+			buf.Write(probs[:])
+		case compiledNumSplit:
+			writeUvarint(&buf, uint64(node.attr))
+			writeVarint(&buf, node.threshold)
+			writeUvarint(&buf, uint64(node.first))
+			writeUvarint(&buf, uint64(node.second))
+		case compiledValSplit:
+			writeUvarint(&buf, uint64(node.attr))
+			writeUvarint(&buf, uint64(node.second-node.first))
+			for _, edge := range trees.edges[node.first:node.second] {
+				writeVarint(&buf, edge.val)
+				writeUvarint(&buf, uint64(edge.child))
+			}
+		}
+	}
+	writeUvarint(&buf, uint64(len(trees.roots)))
+	for _, root := range trees.roots {
+		writeUvarint(&buf, uint64(root))
+	}
+
+	return buf.Bytes()
+}
+
+// decodeForest decodes a forest which was serialized
+// with Forest.encode().
+func decodeForest(d []byte) (res *Forest, err error) {
+	defer func() {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+	}()
+
+	if !bytes.HasPrefix(d, []byte(forestMagic)) {
+		return nil, errors.New("invalid Forest data")
+	}
+	r := bufio.NewReader(bytes.NewReader(d[len(forestMagic):]))
+	version, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	} else if version != forestVersion {
+		return nil, errors.New("unsupported Forest version")
+	}
+
+	header := make([]uint64, 4)
+	for i := range header {
+		if header[i], err = binary.ReadUvarint(r); err != nil {
+			return nil, err
+		}
+	}
+	features := &ForestFeatures{
+		Bytes:       header[0]&forestFlagBytes != 0,
+		Classes:     header[0]&forestFlagClasses != 0,
+		Transitions: header[0]&forestFlagTransitions != 0,
+		KnownWords:  header[0]&forestFlagKnownWords != 0,
+		Before:      int(header[1]),
+		After:       int(header[2]),
+	}
+	for i := uint64(0); i < header[3]; i++ {
+		word, err := readString(r)
+		if err != nil {
+			return nil, err
+		}
+		features.Vocab = append(features.Vocab, word)
+	}
+
+	res = &Forest{features: features, trees: &compiledForest{}}
+	cutoffBits, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	res.cutoff = math.Float64frombits(cutoffBits)
+	if hasStats, err := binary.ReadUvarint(r); err != nil {
+		return nil, err
+	} else if hasStats != 0 {
+		if res.stats, err = readForestStats(r); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := res.trees.decode(r, features); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func readForestStats(r *bufio.Reader) (*ForestStats, error) {
+	errBits, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	res := &ForestStats{
+		OOBError:   math.Float64frombits(errBits),
+		Importance: map[string]float64{},
+	}
+	numNames, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < numNames; i++ {
+		name, err := readString(r)
+		if err != nil {
+			return nil, err
+		}
+		bits, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		res.Importance[name] = math.Float64frombits(bits)
+	}
+	return res, nil
+}
+
+// decode reads the attributes and trees written by
+// Forest.encode(), checking that every tree terminates
+// and only uses attributes the features can compute.
+func (c *compiledForest) decode(r *bufio.Reader, features *ForestFeatures) error {
+	numAttrs, err := binary.ReadUvarint(r)
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < numAttrs; i++ {
+		kind, err := binary.ReadUvarint(r)
+		if err != nil {
+			return err
+		}
+		offset, err := binary.ReadVarint(r)
+		if err != nil {
+			return err
+		}
+		switch {
+		case kind == forestLegacyAttrKind:
+			c.attrs = append(c.attrs, int(offset))
+		case kind <= uint64(forestAttrTransition):
+			c.attrs = append(c.attrs, forestAttr{Kind: forestAttrKind(kind), Offset: int(offset)})
+		case kind <= uint64(forestAttrWordStarting) && offset == 0 && features.KnownWords:
+			c.attrs = append(c.attrs, forestAttr{Kind: forestAttrKind(kind)})
+		default:
+			return errors.New("invalid Forest attribute")
+		}
+	}
+
+	numNodes, err := binary.ReadUvarint(r)
+	if err != nil {
+		return err
+	} else if numNodes > math.MaxInt32 {
+		return errors.New("too many Forest nodes")
+	}
+	checkChild := func(parent int, child uint64) (int32, error) {
+		if child <= uint64(parent) || child >= numNodes {
+			return 0, errors.New("invalid Forest node index")
+		}
+		return int32(child), nil
+	}
+	for i := 0; uint64(i) < numNodes; i++ {
+		kind, err := binary.ReadUvarint(r)
+		if err != nil {
+			return err
+		}
+		if kind > uint64(compiledValSplit) {
+			return errors.New("invalid Forest node kind")
+		}
+		node := compiledNode{kind: compiledNodeKind(kind)}
+		switch node.kind {
+		case compiledLeaf:
+			var probs [8]byte
+			if _, err := io.ReadFull(r, probs[:]); err != nil {
+				return err
+			}
+			node.boundary = math.Float32frombits(binary.LittleEndian.Uint32(probs[:4]))
+			node.inside = math.Float32frombits(binary.LittleEndian.Uint32(probs[4:]))
+			c.nodes = append(c.nodes, node)
+			continue
+		}
+
+		attr, err := binary.ReadUvarint(r)
+		if err != nil {
+			return err
+		} else if attr >= uint64(len(c.attrs)) {
+			return errors.New("invalid Forest attribute index")
+		}
+		node.attr = int32(attr)
+
+		if node.kind == compiledNumSplit {
+			if node.threshold, err = binary.ReadVarint(r); err != nil {
+				return err
+			}
+			for _, child := range []*int32{&node.first, &node.second} {
+				idx, err := binary.ReadUvarint(r)
+				if err != nil {
+					return err
+				}
+				if *child, err = checkChild(i, idx); err != nil {
+					return err
+				}
+			}
+		} else {
+			numEdges, err := binary.ReadUvarint(r)
+			if err != nil {
+				return err
+			}
+			node.first = int32(len(c.edges))
+			for j := uint64(0); j < numEdges; j++ {
+				var edge compiledEdge
+				if edge.val, err = binary.ReadVarint(r); err != nil {
+					return err
+				}
+				idx, err := binary.ReadUvarint(r)
+				if err != nil {
+					return err
+				}
+				if edge.child, err = checkChild(i, idx); err != nil {
+					return err
+				}
+				if j > 0 && edge.val <= c.edges[len(c.edges)-1].val {
+					return errors.New("unsorted Forest edges")
+				}
+				c.edges = append(c.edges, edge)
+			}
+			node.second = int32(len(c.edges))
+		}
+		c.nodes = append(c.nodes, node)
+	}
+
+	numRoots, err := binary.ReadUvarint(r)
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < numRoots; i++ {
+		root, err := binary.ReadUvarint(r)
+		if err != nil {
+			return err
+		} else if root >= numNodes {
+			return errors.New("invalid Forest node index")
+		}
+		c.roots = append(c.roots, int32(root))
+	}
+	return nil
+}
+
+// decodeForestGob decodes a forest which was serialized
+// with gob, either with its features or, for forests
+// from before features were configurable, as a bare
+// idtrees.Forest.
+func decodeForestGob(d []byte) (*Forest, error) {
+	var data forestData
+	if err := gob.NewDecoder(bytes.NewBuffer(d)).Decode(&data); err == nil {
+		if data.Features == nil {
+			return nil, errors.New("missing forest features")
+		}
+		trees, err := compileForest(data.Trees)
+		if err != nil {
+			return nil, err
+		}
+		return &Forest{
+			features: data.Features,
+			trees:    trees,
+			cutoff:   data.Cutoff,
+			stats:    data.Stats,
+		}, nil
+	}
+	var legacy idtrees.Forest
+	if err := gob.NewDecoder(bytes.NewBuffer(d)).Decode(&legacy); err != nil {
+		return nil, err
+	}
+	trees, err := compileForest(legacy)
+	if err != nil {
+		return nil, err
+	}
+	return &Forest{features: legacyForestFeatures(), trees: trees}, nil
+}
+
+func writeVarint(w *bytes.Buffer, x int64) {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(buf[:], x)
+	w.Write(buf[:n])
+}
+
+func writeString(w *bytes.Buffer, s string) {
+	writeUvarint(w, uint64(len(s)))
+	w.WriteString(s)
+}
+
+// readString reads a string written with writeString.
+// The string grows as it is read, so a corrupt length
+// causes an error rather than a huge allocation.
+func readString(r *bufio.Reader) (string, error) {
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", err
+	} else if length > math.MaxInt64 {
+		return "", errors.New("invalid string length")
+	}
+	var res bytes.Buffer
+	if _, err := io.CopyN(&res, r, int64(length)); err != nil {
+		return "", err
+	}
+	return res.String(), nil
+}
@@ -0,0 +1,154 @@
+package spacesplice
+
+import (
+	"bytes"
+	"encoding/gob"
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/unixpickle/weakai/idtrees"
+)
+
+var testForestTexts = []string{
+	"Thedocumentationconsistsoftwoparts.",
+	"Themodelsplitstextintowords.",
+	"UNSEENwords123andnaïvecafé",
+}
+
+func TestForestEncoding(t *testing.T) {
+	f, err := TrainForest(testCorpusDir(t), &ForestConfig{Seed: 1337, NumTrees: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := f.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DeserializeForest(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.NumTrees() != f.NumTrees() || decoded.Cutoff() != f.Cutoff() {
+		t.Errorf("expected %d trees and cutoff %f but got %d and %f", f.NumTrees(),
+			f.Cutoff(), decoded.NumTrees(), decoded.Cutoff())
+	}
+	if !reflect.DeepEqual(decoded.Stats(), f.Stats()) {
+		t.Errorf("expected stats %v but got %v", f.Stats(), decoded.Stats())
+	}
+	expectedFeatures, actualFeatures := *f.Features(), *decoded.Features()
+	expectedFeatures.trie, actualFeatures.trie = nil, nil
+	if !reflect.DeepEqual(actualFeatures, expectedFeatures) {
+		t.Errorf("expected features %v but got %v", expectedFeatures, actualFeatures)
+	}
+	for _, text := range testForestTexts {
+		expected, actual := f.Fields(text), decoded.Fields(text)
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("text %q: expected %q but got %q", text, expected, actual)
+		}
+	}
+
+	reencoded, err := decoded.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, reencoded) {
+		t.Error("re-encoding the forest changed its data")
+	}
+}
+
+func TestForestEncodingCorrupt(t *testing.T) {
+	f, err := TrainForest(testCorpusDir(t), &ForestConfig{Seed: 1337, NumTrees: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := f.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := len(forestMagic); i < len(data); i++ {
+		if _, err := DeserializeForest(data[:i]); err == nil {
+			t.Errorf("no error for data truncated to %d bytes", i)
+		}
+	}
+	gen := rand.New(rand.NewSource(1337))
+	for i := 0; i < 1000; i++ {
+		corrupt := append([]byte{}, data...)
+		for j := 0; j < 4; j++ {
+			corrupt[len(forestMagic)+gen.Intn(len(corrupt)-len(forestMagic))] =
+				byte(gen.Intn(256))
+		}
+		// Corrupt data may still decode, but it must not
+		// cause a panic, and the forest must be usable.
+		if decoded, err := DeserializeForest(corrupt); err == nil {
+			for _, text := range testForestTexts {
+				decoded.Fields(text)
+			}
+		}
+	}
+}
+
+func TestForestGob(t *testing.T) {
+	var docs [][]string
+	for _, sample := range testCorpus {
+		docs = append(docs, Tokenize(sample))
+	}
+	config := &ForestConfig{Seed: 1337, NumTrees: 5}
+
+	features := DefaultForestFeatures()
+	features.trainVocab(docs)
+	samples := forestSamples(features, docs)
+	trees, _ := buildForest(samples, features.attrs(), config)
+	var buf bytes.Buffer
+	data := &forestData{Features: features, Trees: trees, Cutoff: 1.5}
+	if err := gob.NewEncoder(&buf).Encode(data); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DeserializeForest(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Cutoff() != 1.5 {
+		t.Errorf("expected cutoff 1.5 but got %f", decoded.Cutoff())
+	}
+	testForestMatchesTrees(t, decoded, trees, forestSamples(decoded.Features(), docs))
+
+	// Forests from before features were configurable were
+	// bare trees which split on byte offsets.
+	features = legacyForestFeatures()
+	samples = forestSamples(features, docs)
+	var attrs []idtrees.Attr
+	for offset := -features.Before; offset <= features.After; offset++ {
+		attrs = append(attrs, offset)
+	}
+	trees, _ = buildForest(samples, attrs, config)
+	buf.Reset()
+	if err := gob.NewEncoder(&buf).Encode(trees); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err = DeserializeForest(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Cutoff() != forestDefaultCutoff {
+		t.Errorf("expected default cutoff but got %f", decoded.Cutoff())
+	}
+	testForestMatchesTrees(t, decoded, trees, samples)
+}
+
+// testForestMatchesTrees checks that the compiled trees
+// of a forest classify samples like the trees they were
+// compiled from.
+func testForestMatchesTrees(t *testing.T, f *Forest, trees idtrees.Forest,
+	samples []idtrees.Sample) {
+	for i, sample := range samples {
+		expected := trees.Classify(sample)
+		boundary, inside := f.trees.classify(f.trees.values(sample))
+		boundary /= float64(len(trees))
+		inside /= float64(len(trees))
+		if math.Abs(boundary-expected[true]) > 1e-5 || math.Abs(inside-expected[false]) > 1e-5 {
+			t.Fatalf("sample %d: expected %v but got %f, %f", i, expected, boundary, inside)
+		}
+	}
+}
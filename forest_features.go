@@ -0,0 +1,285 @@
+package spacesplice
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/unixpickle/weakai/idtrees"
+)
+
+const (
+	forestDefaultBefore = 7
+	forestDefaultAfter  = 3
+
+	// forestMinWordCount is the number of times a word
+	// must appear in the corpus to be a known word.
+	forestMinWordCount = 2
+)
+
+func init() {
+	gob.Register(forestAttr{})
+}
+
+// ForestFeatures configures the attributes which the
+// trees of a Forest use to decide if a field ends at a
+// given byte of the text.
+//
+// Most features are computed for each byte in a window
+// around the byte being classified.
+type ForestFeatures struct {
+	// Before and After are the number of bytes in the
+	// window before and after the byte being classified.
+	Before int
+	After  int
+
+	// Bytes enables the raw value of each byte.
+	Bytes bool
+
+	// Classes enables the class of the character at each
+	// byte, such as lower-case letter, digit, or
+	// punctuation.
+	Classes bool
+
+	// Transitions enables the pair of character classes
+	// at each byte and the byte after it, so that a tree
+	// can split on case changes and on transitions
+	// between letters and digits.
+	Transitions bool
+
+	// KnownWords enables the lengths of the longest known
+	// words ending at the byte and starting after it.
+	KnownWords bool
+
+	// Vocab lists the known words, in lower case.
+	Vocab []string
+
+	trie *trie
+}
+
+// DefaultForestFeatures returns the default feature
+// configuration, which enables every feature.
+func DefaultForestFeatures() *ForestFeatures {
+	return &ForestFeatures{
+		Before:      forestDefaultBefore,
+		After:       forestDefaultAfter,
+		Bytes:       true,
+		Classes:     true,
+		Transitions: true,
+		KnownWords:  true,
+	}
+}
+
+// ParseForestFeatures creates a feature configuration
+// from a comma-separated list of feature names, which
+// may include "bytes", "classes", "transitions", and
+// "words".
+func ParseForestFeatures(list string, before, after int) (*ForestFeatures, error) {
+	if before < 0 || after < 0 {
+		return nil, errors.New("invalid feature window")
+	}
+	res := &ForestFeatures{Before: before, After: after}
+	for _, name := range strings.Split(list, ",") {
+		switch strings.TrimSpace(name) {
+		case "bytes":
+			res.Bytes = true
+		case "classes":
+			res.Classes = true
+		case "transitions":
+			res.Transitions = true
+		case "words":
+			res.KnownWords = true
+		case "":
+		default:
+			return nil, errors.New("unknown forest feature: " + name)
+		}
+	}
+	return res, nil
+}
+
+// legacyForestFeatures returns the features used by
+// forests from before features were configurable.
+func legacyForestFeatures() *ForestFeatures {
+	return &ForestFeatures{Before: 7, After: 3, Bytes: true}
+}
+
+// attrs returns every attribute enabled by the
+// configuration.
+func (f *ForestFeatures) attrs() []idtrees.Attr {
+	var res []idtrees.Attr
+	for offset := -f.Before; offset <= f.After; offset++ {
+		if f.Bytes {
+			res = append(res, forestAttr{Kind: forestAttrByte, Offset: offset})
+		}
+		if f.Classes {
+			res = append(res, forestAttr{Kind: forestAttrClass, Offset: offset})
+		}
+		if f.Transitions && offset < f.After {
+			res = append(res, forestAttr{Kind: forestAttrTransition, Offset: offset})
+		}
+	}
+	if f.KnownWords {
+		res = append(res, forestAttr{Kind: forestAttrWordEnding},
+			forestAttr{Kind: forestAttrWordStarting})
+	}
+	return res
+}
+
+// trainVocab sets f.Vocab to the words which appear at
+// least forestMinWordCount times in the samples, sorted
+// so that the same samples always give the same model.
+func (f *ForestFeatures) trainVocab(docs [][]string) {
+	counts := map[string]int{}
+	for _, fields := range docs {
+		for _, field := range fields {
+			counts[foldCase(field)]++
+		}
+	}
+	f.Vocab = nil
+	for word, count := range counts {
+		if count >= forestMinWordCount {
+			f.Vocab = append(f.Vocab, word)
+		}
+	}
+	sort.Strings(f.Vocab)
+	f.buildTrie()
+}
+
+// buildTrie builds the trie of f.Vocab which is used to
+// find known words.
+// It must be called whenever Vocab changes, before the
+// features are used.
+func (f *ForestFeatures) buildTrie() {
+	f.trie = newTrie(f.Vocab)
+}
+
+// newDoc prepares a piece of text for classification.
+func (f *ForestFeatures) newDoc(text string) *forestDoc {
+	res := &forestDoc{text: text}
+	if !f.KnownWords {
+		return res
+	}
+	res.wordEnding = make([]int64, len(text))
+	res.wordStarting = make([]int64, len(text))
+	folded := foldCase(text)
+	for start := range folded {
+		f.trie.prefixes(folded[start:], func(word string) {
+			end := start + len(word) - 1
+			length := int64(len(word))
+			if length > res.wordEnding[end] {
+				res.wordEnding[end] = length
+			}
+			if start > 0 && length > res.wordStarting[start-1] {
+				res.wordStarting[start-1] = length
+			}
+		})
+	}
+	return res
+}
+
+type forestAttrKind int
+
+const (
+	forestAttrByte forestAttrKind = iota
+	forestAttrClass
+	forestAttrTransition
+	forestAttrWordEnding
+	forestAttrWordStarting
+)
+
+// forestAttr identifies an attribute of a forestSample.
+type forestAttr struct {
+	Kind   forestAttrKind
+	Offset int
+}
+
+// String returns a human-readable name for the
+// attribute.
+func (f forestAttr) String() string {
+	switch f.Kind {
+	case forestAttrByte:
+		return fmt.Sprintf("byte[%+d]", f.Offset)
+	case forestAttrClass:
+		return fmt.Sprintf("class[%+d]", f.Offset)
+	case forestAttrTransition:
+		return fmt.Sprintf("transition[%+d]", f.Offset)
+	case forestAttrWordEnding:
+		return "word-ending"
+	case forestAttrWordStarting:
+		return "word-starting"
+	}
+	return fmt.Sprintf("unknown(%d)", f.Kind)
+}
+
+// forestDoc is a piece of text along with the known
+// word lengths at each byte.
+type forestDoc struct {
+	text         string
+	wordEnding   []int64
+	wordStarting []int64
+}
+
+// byteAttr returns an attribute of the byte at idx.
+func (f *forestDoc) byteAttr(kind forestAttrKind, idx int) idtrees.Val {
+	switch kind {
+	case forestAttrByte:
+		if idx < 0 || idx >= len(f.text) {
+			return uint8(0)
+		}
+		return f.text[idx]
+	case forestAttrClass:
+		return f.charClass(idx)
+	case forestAttrTransition:
+		return f.charClass(idx)*forestClassCount + f.charClass(idx+1)
+	case forestAttrWordEnding:
+		return f.wordEnding[idx]
+	case forestAttrWordStarting:
+		return f.wordStarting[idx]
+	}
+	panic("unknown attribute kind")
+}
+
+const (
+	forestClassNone uint8 = iota
+	forestClassLower
+	forestClassUpper
+	forestClassLetter
+	forestClassDigit
+	forestClassPunct
+	forestClassSpace
+	forestClassOther
+	forestClassCount
+)
+
+// charClass classifies the character containing the
+// byte at idx.
+func (f *forestDoc) charClass(idx int) uint8 {
+	if idx < 0 || idx >= len(f.text) {
+		return forestClassNone
+	}
+	start := idx
+	for start > 0 && idx-start < utf8.UTFMax-1 && !utf8.RuneStart(f.text[start]) {
+		start--
+	}
+	r, _ := utf8.DecodeRuneInString(f.text[start:])
+	switch {
+	case unicode.IsLower(r):
+		return forestClassLower
+	case unicode.IsUpper(r):
+		return forestClassUpper
+	case unicode.IsLetter(r) || unicode.IsMark(r):
+		return forestClassLetter
+	case unicode.IsDigit(r):
+		return forestClassDigit
+	case unicode.IsPunct(r) || unicode.IsSymbol(r):
+		return forestClassPunct
+	case unicode.IsSpace(r):
+		return forestClassSpace
+	default:
+		return forestClassOther
+	}
+}
@@ -0,0 +1,130 @@
+package spacesplice
+
+import (
+	"math/rand"
+
+	"github.com/unixpickle/weakai/idtrees"
+)
+
+const (
+	forestOOBSamples        = 20000
+	forestImportanceSamples = 2000
+)
+
+// ForestStats stores diagnostics which are computed on
+// out-of-bag samples while a Forest is trained.
+// A sample is out-of-bag for the trees which were not
+// trained on it.
+type ForestStats struct {
+	// OOBError is the fraction of out-of-bag samples
+	// whose boundaries were misclassified by the trees
+	// which did not see them.
+	OOBError float64
+
+	// Importance maps the name of each attribute to the
+	// increase in out-of-bag error when the attribute's
+	// values are shuffled between samples.
+	Importance map[string]float64
+}
+
+// computeStats computes the out-of-bag error and the
+// permutation importance of each attribute, using a
+// random subset of the training samples.
+// The bags list the samples used to train each tree.
+func (f *Forest) computeStats(samples []idtrees.Sample, bags [][]int, attrs []idtrees.Attr,
+	seed int64) *ForestStats {
+	gen := rand.New(rand.NewSource(seed))
+	numOOB := forestOOBSamples
+	if numOOB > len(samples) {
+		numOOB = len(samples)
+	}
+	values := make([][]int64, numOOB)
+	classes := make([]bool, numOOB)
+	positions := map[int]int{}
+	for i, idx := range randomSubset(gen, len(samples), numOOB) {
+		values[i] = f.trees.values(samples[idx])
+		classes[i] = samples[idx].Class().(bool)
+		positions[idx] = i
+	}
+	inBag := make([][]bool, numOOB)
+	for i := range inBag {
+		inBag[i] = make([]bool, len(f.trees.roots))
+	}
+	for treeIdx, bag := range bags {
+		for _, idx := range bag {
+			if pos, ok := positions[idx]; ok {
+				inBag[pos][treeIdx] = true
+			}
+		}
+	}
+
+	res := &ForestStats{
+		OOBError:   f.oobError(values, classes, inBag),
+		Importance: map[string]float64{},
+	}
+
+	numImportance := forestImportanceSamples
+	if numImportance > numOOB {
+		numImportance = numOOB
+	}
+	values = values[:numImportance]
+	classes = classes[:numImportance]
+	inBag = inBag[:numImportance]
+	baseError := f.oobError(values, classes, inBag)
+	attrIndices := map[idtrees.Attr]int{}
+	for i, attr := range f.trees.attrs {
+		attrIndices[attr] = i
+	}
+	for _, attr := range attrs {
+		perm := gen.Perm(numImportance)
+		name := attr.(forestAttr).String()
+		attrIdx, ok := attrIndices[attr]
+		if !ok {
+			// No tree splits on the attribute.
+			res.Importance[name] = 0
+			continue
+		}
+		permuted := make([][]int64, numImportance)
+		for i, sampleValues := range values {
+			permuted[i] = append([]int64{}, sampleValues...)
+			permuted[i][attrIdx] = values[perm[i]][attrIdx]
+		}
+		res.Importance[name] = f.oobError(permuted, classes, inBag) - baseError
+	}
+	return res
+}
+
+// oobError computes the fraction of samples which are
+// misclassified by the trees that were not trained on
+// them.
+// Samples which every tree was trained on are ignored.
+func (f *Forest) oobError(values [][]int64, classes []bool, inBag [][]bool) float64 {
+	wrong := make([]bool, len(values))
+	used := make([]bool, len(values))
+	parallelFor(len(values), func(i int) {
+		var boundary, inside float64
+		for treeIdx := range f.trees.roots {
+			if inBag[i][treeIdx] {
+				continue
+			}
+			used[i] = true
+			b, in := f.trees.classifyTree(treeIdx, values[i])
+			boundary += float64(b)
+			inside += float64(in)
+		}
+		wrong[i] = used[i] && f.isBoundary(boundary, inside) != classes[i]
+	})
+	var numWrong, numUsed int
+	for i, w := range wrong {
+		if w {
+			numWrong++
+		}
+		if used[i] {
+			numUsed++
+		}
+	}
+	if numUsed == 0 {
+		return 0
+	}
+	return float64(numWrong) / float64(numUsed)
+}
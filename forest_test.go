@@ -0,0 +1,184 @@
+package spacesplice
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/unixpickle/weakai/idtrees"
+)
+
+func TestForestReproducible(t *testing.T) {
+	features := DefaultForestFeatures()
+	config := &ForestConfig{Features: features, Seed: 1337, NumTrees: 10}
+	f1, err := TrainForest(testCorpusDir(t), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if features.Vocab != nil {
+		t.Error("training modified the configured features")
+	}
+	f2, err := TrainForest(testCorpusDir(t), &ForestConfig{Seed: 1337, NumTrees: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data1, err := f1.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data2, err := f2.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data1, data2) {
+		t.Error("forests trained with the same seed serialized differently")
+	}
+}
+
+func TestForestCalibrateCutoff(t *testing.T) {
+	config := &ForestConfig{Seed: 1337, NumTrees: 10, Unbalanced: true}
+	f, err := TrainForest(testCorpusDir(t), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var docs [][]string
+	for _, sample := range testCorpus {
+		docs = append(docs, Tokenize(sample))
+	}
+	samples := forestSamples(f.features, docs)
+
+	cutoff, f1 := f.calibrateCutoff(samples)
+	if actual := testForestF1(f, samples, cutoff); math.Abs(actual-f1) > 1e-8 {
+		t.Errorf("cutoff %f: expected F1 %f but got %f", cutoff, f1, actual)
+	}
+	for _, other := range []float64{0.1, 0.25, 0.5, 1, 2, 4, 10} {
+		if actual := testForestF1(f, samples, other); actual > f1+1e-8 {
+			t.Errorf("cutoff %f has F1 %f, which beats calibrated F1 %f", other, actual, f1)
+		}
+	}
+
+	var inside []idtrees.Sample
+	for _, sample := range samples {
+		if !sample.Class().(bool) {
+			inside = append(inside, sample)
+		}
+	}
+	if cutoff, f1 := f.calibrateCutoff(inside); cutoff != forestDefaultCutoff || f1 != 0 {
+		t.Errorf("without boundaries: expected cutoff %f but got %f (F1 %f)",
+			forestDefaultCutoff, cutoff, f1)
+	}
+}
+
+func TestForestHeldOutBalanced(t *testing.T) {
+	dir := testCorpusDir(t)
+	configs := []*ForestConfig{
+		{NumTrees: 1, HeldOut: 0.2},
+		{NumTrees: 1, HeldOut: 0.2, Unbalanced: true, Cutoff: 2},
+	}
+	for _, config := range configs {
+		if _, err := TrainForest(dir, config); err == nil {
+			t.Errorf("expected an error for config %+v", config)
+		}
+	}
+	config := &ForestConfig{NumTrees: 1, HeldOut: 0.2, Unbalanced: true}
+	if _, err := TrainForest(dir, config); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestForestStats(t *testing.T) {
+	f, err := TrainForest(testCorpusDir(t), &ForestConfig{Seed: 1337, NumTrees: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := f.Stats()
+	if stats == nil {
+		t.Fatal("no statistics were computed")
+	}
+	if stats.OOBError < 0 || stats.OOBError > 1 {
+		t.Errorf("invalid out-of-bag error %f", stats.OOBError)
+	}
+	attrs := f.Features().attrs()
+	if len(stats.Importance) != len(attrs) {
+		t.Errorf("expected %d importances but got %d", len(attrs), len(stats.Importance))
+	}
+	for _, attr := range attrs {
+		if _, ok := stats.Importance[attr.(forestAttr).String()]; !ok {
+			t.Errorf("missing importance of %s", attr)
+		}
+	}
+
+	data, err := f.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DeserializeForest(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded.Stats(), stats) {
+		t.Errorf("expected stats %v but got %v", stats, decoded.Stats())
+	}
+}
+
+func TestBalancedSubset(t *testing.T) {
+	cases := []struct {
+		sizes    [2]int
+		k        int
+		expected [2]int
+	}{
+		{[2]int{50, 200}, 40, [2]int{20, 20}},
+		{[2]int{50, 200}, 41, [2]int{20, 21}},
+		{[2]int{20, 20}, 40, [2]int{20, 20}},
+		{[2]int{5, 200}, 40, [2]int{5, 35}},
+		{[2]int{200, 5}, 40, [2]int{35, 5}},
+		{[2]int{0, 200}, 40, [2]int{0, 40}},
+	}
+	gen := rand.New(rand.NewSource(1337))
+	for _, c := range cases {
+		var classIndices [2][]int
+		class := map[int]int{}
+		var next int
+		for i, size := range c.sizes {
+			for j := 0; j < size; j++ {
+				classIndices[i] = append(classIndices[i], next)
+				class[next] = i
+				next++
+			}
+		}
+		var counts [2]int
+		seen := map[int]bool{}
+		for _, idx := range balancedSubset(gen, classIndices, c.k) {
+			if seen[idx] {
+				t.Errorf("sizes %v: index %d was chosen twice", c.sizes, idx)
+			}
+			seen[idx] = true
+			counts[class[idx]]++
+		}
+		if counts != c.expected {
+			t.Errorf("sizes %v, k=%d: expected class counts %v but got %v", c.sizes, c.k,
+				c.expected, counts)
+		}
+	}
+}
+
+// testForestF1 computes the F1 score of the boundaries
+// which f predicts with the given cutoff.
+func testForestF1(f *Forest, samples []idtrees.Sample, cutoff float64) float64 {
+	var truePos, falsePos, falseNeg int
+	for _, sample := range samples {
+		boundary, inside := f.trees.classify(f.trees.values(sample))
+		predicted := boundary >= inside*cutoff
+		actual := sample.Class().(bool)
+		if predicted && actual {
+			truePos++
+		} else if predicted {
+			falsePos++
+		} else if actual {
+			falseNeg++
+		}
+	}
+	return 2 * float64(truePos) / float64(2*truePos+falsePos+falseNeg)
+}
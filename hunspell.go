@@ -0,0 +1,337 @@
+package spacesplice
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// LoadHunspell creates a Dictionary from a Hunspell
+// dictionary, consisting of a .dic file and a .aff file.
+//
+// Every word in the .dic file is expanded with the
+// prefixes and suffixes it allows, and each resulting
+// word is given a count of 1.
+// Continuation classes on affixes, compounding, and
+// most other Hunspell features are ignored.
+//
+// ignoreCase works like it does for TrainDictionary.
+func LoadHunspell(dicPath, affPath string, mode DictionaryMode,
+	ignoreCase bool) (*Dictionary, error) {
+	if !mode.valid() {
+		return nil, errors.New("unknown dictionary mode: " + string(mode))
+	}
+	affData, err := ioutil.ReadFile(affPath)
+	if err != nil {
+		return nil, err
+	}
+	dicData, err := ioutil.ReadFile(dicPath)
+	if err != nil {
+		return nil, err
+	}
+	aff, err := parseHunspellAff(affData)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %s", affPath, err)
+	}
+	counts := map[string]int{}
+	err = aff.expandDic(aff.decode(dicData), func(word string) {
+		if ignoreCase {
+			word = foldCase(word)
+		}
+		counts[word] = 1
+	})
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %s", dicPath, err)
+	}
+	return NewDictionary(counts, mode), nil
+}
+
+// hunspellAff stores the parts of a Hunspell .aff file
+// which are needed to expand a .dic file.
+type hunspellAff struct {
+	latin1     bool
+	flagType   string
+	aliases    [][]string
+	affixes    map[string]*hunspellAffix
+	needAffix  string
+	forbidden  string
+	affixOrder []string
+}
+
+type hunspellAffix struct {
+	prefix bool
+	cross  bool
+	rules  []hunspellRule
+}
+
+type hunspellRule struct {
+	strip     string
+	add       string
+	condition *regexp.Regexp
+}
+
+func parseHunspellAff(data []byte) (*hunspellAff, error) {
+	res := &hunspellAff{affixes: map[string]*hunspellAffix{}}
+	for _, line := range strings.Split(string(data), "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 && fields[0] == "SET" {
+			switch strings.ToUpper(fields[1]) {
+			case "UTF-8", "UTF8":
+			case "ISO8859-1", "ISO-8859-1":
+				res.latin1 = true
+			default:
+				return nil, errors.New("unsupported encoding: " + fields[1])
+			}
+		}
+	}
+
+	for i, line := range strings.Split(res.decode(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		var err error
+		switch fields[0] {
+		case "FLAG":
+			res.flagType = fields[1]
+		case "NEEDAFFIX":
+			res.needAffix = fields[1]
+		case "FORBIDDENWORD":
+			res.forbidden = fields[1]
+		case "AF":
+			if len(res.aliases) == 0 && isNumber(fields[1]) {
+				// The first AF line gives the number of aliases.
+				res.aliases = [][]string{nil}
+			} else {
+				res.aliases = append(res.aliases, res.parseFlags(fields[1]))
+			}
+		case "PFX", "SFX":
+			err = res.parseAffixLine(fields)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", i+1, err)
+		}
+	}
+	return res, nil
+}
+
+// parseAffixLine parses a PFX or SFX line, which is
+// either the header of an affix class or one of its
+// rules.
+func (h *hunspellAff) parseAffixLine(fields []string) error {
+	if len(fields) < 4 {
+		return errors.New("too few fields")
+	}
+	flag := fields[1]
+	affix, ok := h.affixes[flag]
+	if !ok {
+		h.affixes[flag] = &hunspellAffix{
+			prefix: fields[0] == "PFX",
+			cross:  fields[2] == "Y",
+		}
+		h.affixOrder = append(h.affixOrder, flag)
+		return nil
+	}
+	rule := hunspellRule{strip: fields[2], add: fields[3]}
+	if rule.strip == "0" {
+		rule.strip = ""
+	}
+	if idx := strings.Index(rule.add, "/"); idx >= 0 {
+		rule.add = rule.add[:idx]
+	}
+	if rule.add == "0" {
+		rule.add = ""
+	}
+	condition := "."
+	if len(fields) > 4 {
+		condition = fields[4]
+	}
+	var err error
+	rule.condition, err = hunspellCondition(condition, affix.prefix)
+	if err != nil {
+		return err
+	}
+	affix.rules = append(affix.rules, rule)
+	return nil
+}
+
+// expandDic calls f with every word in a .dic file and
+// every affixed form of each word.
+func (h *hunspellAff) expandDic(dic string, f func(word string)) error {
+	lines := strings.Split(dic, "\n")
+	for i, line := range lines[1:] {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		word, flags, err := h.parseDicEntry(fields[0])
+		if err != nil {
+			return fmt.Errorf("line %d: %s", i+2, err)
+		}
+		h.expandWord(word, flags, f)
+	}
+	return nil
+}
+
+func (h *hunspellAff) parseDicEntry(entry string) (word string, flags []string, err error) {
+	slashIdx := -1
+	for i := 0; i < len(entry); i++ {
+		if entry[i] == '\\' {
+			i++
+		} else if entry[i] == '/' {
+			slashIdx = i
+			break
+		}
+	}
+	word = entry
+	if slashIdx >= 0 {
+		word = entry[:slashIdx]
+		flagStr := entry[slashIdx+1:]
+		if len(h.aliases) > 0 {
+			idx, err := strconv.Atoi(flagStr)
+			if err != nil || idx < 1 || idx >= len(h.aliases) {
+				return "", nil, errors.New("invalid flag alias: " + flagStr)
+			}
+			flags = h.aliases[idx]
+		} else {
+			flags = h.parseFlags(flagStr)
+		}
+	}
+	return strings.Replace(word, "\\/", "/", -1), flags, nil
+}
+
+func (h *hunspellAff) expandWord(word string, flags []string, f func(word string)) {
+	flagSet := map[string]bool{}
+	for _, flag := range flags {
+		flagSet[flag] = true
+	}
+	if flagSet[h.forbidden] && h.forbidden != "" {
+		return
+	}
+	if !flagSet[h.needAffix] || h.needAffix == "" {
+		f(word)
+	}
+
+	var prefixes []*hunspellAffix
+	for _, flag := range h.affixOrder {
+		if affix := h.affixes[flag]; flagSet[flag] && affix.prefix {
+			prefixes = append(prefixes, affix)
+			affix.apply(word, f)
+		}
+	}
+	for _, flag := range h.affixOrder {
+		affix := h.affixes[flag]
+		if !flagSet[flag] || affix.prefix {
+			continue
+		}
+		affix.apply(word, func(suffixed string) {
+			f(suffixed)
+			if !affix.cross {
+				return
+			}
+			for _, prefix := range prefixes {
+				if prefix.cross {
+					prefix.apply(suffixed, f)
+				}
+			}
+		})
+	}
+}
+
+// parseFlags splits a string of flags according to the
+// FLAG setting of the .aff file.
+func (h *hunspellAff) parseFlags(s string) []string {
+	var res []string
+	switch h.flagType {
+	case "long":
+		for i := 0; i < len(s); i += 2 {
+			res = append(res, s[i:minInt(i+2, len(s))])
+		}
+	case "num":
+		res = strings.Split(s, ",")
+	default:
+		for _, r := range s {
+			res = append(res, string(r))
+		}
+	}
+	return res
+}
+
+// decode converts the contents of a dictionary file to
+// UTF-8.
+func (h *hunspellAff) decode(data []byte) string {
+	if !h.latin1 {
+		return string(data)
+	}
+	res := make([]byte, 0, len(data))
+	for _, b := range data {
+		res = append(res, string(rune(b))...)
+	}
+	return string(res)
+}
+
+// apply calls f with every form of the word produced by
+// one of the affix's rules.
+func (h *hunspellAffix) apply(word string, f func(word string)) {
+	for _, rule := range h.rules {
+		if !rule.condition.MatchString(word) {
+			continue
+		}
+		if h.prefix {
+			if strings.HasPrefix(word, rule.strip) && len(word) > len(rule.strip) {
+				f(rule.add + word[len(rule.strip):])
+			}
+		} else if strings.HasSuffix(word, rule.strip) && len(word) > len(rule.strip) {
+			f(word[:len(word)-len(rule.strip)] + rule.add)
+		}
+	}
+}
+
+// hunspellCondition compiles the condition of an affix
+// rule, which is a simplified regular expression that
+// must match the start (for a prefix) or end (for a
+// suffix) of a word.
+func hunspellCondition(cond string, prefix bool) (*regexp.Regexp, error) {
+	var expr bytes.Buffer
+	var inClass bool
+	for _, r := range cond {
+		switch {
+		case inClass:
+			if r == ']' {
+				inClass = false
+			} else if r == '\\' || r == '[' {
+				expr.WriteRune('\\')
+			}
+			expr.WriteRune(r)
+		case r == '[':
+			inClass = true
+			expr.WriteRune(r)
+		case r == '.':
+			expr.WriteRune(r)
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	if inClass {
+		return nil, errors.New("unterminated condition: " + cond)
+	}
+	if prefix {
+		return regexp.Compile("^(?:" + expr.String() + ")")
+	}
+	return regexp.Compile("(?:" + expr.String() + ")$")
+}
+
+func isNumber(s string) bool {
+	_, err := strconv.Atoi(s)
+	return err == nil
+}
+
+func minInt(x, y int) int {
+	if x < y {
+		return x
+	}
+	return y
+}
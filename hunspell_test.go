@@ -0,0 +1,222 @@
+package spacesplice
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestHunspellCondition(t *testing.T) {
+	cases := []struct {
+		cond   string
+		prefix bool
+		word   string
+		match  bool
+	}{
+		{".", false, "word", true},
+		{"y", false, "city", true},
+		{"y", false, "yes", false},
+		{"[^aeiou]y", false, "city", true},
+		{"[^aeiou]y", false, "day", false},
+		{"[aeiou]y", false, "day", true},
+		{"re", true, "redo", true},
+		{"re", true, "are", false},
+		{"[^u]n", true, "undo", false},
+		{"[^u]n", true, "android", true},
+		{"a.c", false, "xabc", true},
+		{"a+", false, "a+", true},
+		{"a+", false, "aa", false},
+		{"[\\]", false, "a\\", true},
+	}
+	for _, c := range cases {
+		expr, err := hunspellCondition(c.cond, c.prefix)
+		if err != nil {
+			t.Errorf("condition %q: %s", c.cond, err)
+			continue
+		}
+		if actual := expr.MatchString(c.word); actual != c.match {
+			t.Errorf("condition %q (prefix=%v) on %q: expected %v but got %v", c.cond,
+				c.prefix, c.word, c.match, actual)
+		}
+	}
+	if _, err := hunspellCondition("[ab", false); err == nil {
+		t.Error("no error for unterminated condition")
+	}
+}
+
+func TestHunspellExpand(t *testing.T) {
+	cases := []struct {
+		name     string
+		aff      string
+		dic      string
+		expected []string
+	}{
+		{
+			name: "strip and condition",
+			aff: "SFX S Y 2\n" +
+				"SFX S y ies [^aeiou]y\n" +
+				"SFX S 0 s [aeiou]y\n",
+			dic:      "2\ncity/S\nday/S\n",
+			expected: []string{"cities", "city", "day", "days"},
+		},
+		{
+			name: "cross product",
+			aff: "PFX U Y 1\n" +
+				"PFX U 0 un .\n" +
+				"SFX D Y 2\n" +
+				"SFX D 0 ed [^e]\n" +
+				"SFX D 0 d e\n",
+			dic:      "2\nlock/UD\ntie/D\n",
+			expected: []string{"lock", "locked", "tie", "tied", "unlock", "unlocked"},
+		},
+		{
+			name: "no cross product",
+			aff: "PFX U Y 1\n" +
+				"PFX U 0 un .\n" +
+				"SFX D N 1\n" +
+				"SFX D 0 ed .\n",
+			dic:      "1\nlock/UD\n",
+			expected: []string{"lock", "locked", "unlock"},
+		},
+		{
+			name: "long flags",
+			aff: "FLAG long\n" +
+				"SFX Aa Y 1\n" +
+				"SFX Aa 0 s .\n" +
+				"SFX Ab Y 1\n" +
+				"SFX Ab 0 ing .\n",
+			dic:      "1\nwork/AaAb\n",
+			expected: []string{"work", "working", "works"},
+		},
+		{
+			name: "numeric flags",
+			aff: "FLAG num\n" +
+				"SFX 101 Y 1\n" +
+				"SFX 101 0 s .\n" +
+				"PFX 7 Y 1\n" +
+				"PFX 7 0 re .\n",
+			dic:      "1\nwork/101,7\n",
+			expected: []string{"rework", "reworks", "work", "works"},
+		},
+		{
+			name: "flag aliases",
+			aff: "AF 2\n" +
+				"AF S\n" +
+				"AF SD\n" +
+				"SFX S Y 1\n" +
+				"SFX S 0 s .\n" +
+				"SFX D Y 1\n" +
+				"SFX D 0 ed .\n",
+			dic:      "2\nwalk/2\nrun/1\n",
+			expected: []string{"run", "runs", "walk", "walked", "walks"},
+		},
+		{
+			name: "affix continuation flags",
+			aff: "SFX S Y 1\n" +
+				"SFX S 0 s/X .\n",
+			dic:      "1\ncat/S\n",
+			expected: []string{"cat", "cats"},
+		},
+		{
+			name: "needaffix and forbidden words",
+			aff: "NEEDAFFIX X\n" +
+				"FORBIDDENWORD F\n" +
+				"SFX S Y 1\n" +
+				"SFX S 0 s .\n",
+			dic:      "3\nbase/XS\nbad/F\nplain\n",
+			expected: []string{"bases", "plain"},
+		},
+		{
+			name:     "escaped slash and blank lines",
+			aff:      "",
+			dic:      "2\n\nand\\/or\n\n",
+			expected: []string{"and/or"},
+		},
+		{
+			name: "latin-1",
+			aff: "SET ISO8859-1\n" +
+				"SFX S Y 1\n" +
+				"SFX S 0 s \xe9\n",
+			dic:      "1\ncaf\xe9/S\n",
+			expected: []string{"café", "cafés"},
+		},
+	}
+	for _, c := range cases {
+		aff, err := parseHunspellAff([]byte(c.aff))
+		if err != nil {
+			t.Errorf("%s: %s", c.name, err)
+			continue
+		}
+		var actual []string
+		err = aff.expandDic(aff.decode([]byte(c.dic)), func(word string) {
+			actual = append(actual, word)
+		})
+		if err != nil {
+			t.Errorf("%s: %s", c.name, err)
+			continue
+		}
+		sort.Strings(actual)
+		if !reflect.DeepEqual(actual, c.expected) {
+			t.Errorf("%s: expected %q but got %q", c.name, c.expected, actual)
+		}
+	}
+}
+
+func TestHunspellMalformed(t *testing.T) {
+	affCases := map[string]string{
+		"unsupported encoding":   "SET KOI8-R\n",
+		"short affix line":       "SFX S Y\n",
+		"short rule":             "SFX S Y 1\nSFX S 0\n",
+		"unterminated condition": "SFX S Y 1\nSFX S 0 s [ab\n",
+	}
+	for name, aff := range affCases {
+		if _, err := parseHunspellAff([]byte(aff)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	aff, err := parseHunspellAff([]byte("AF 1\nAF S\nSFX S Y 1\nSFX S 0 s .\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dic := range []string{"1\nword/2\n", "1\nword/0\n", "1\nword/S\n"} {
+		if err := aff.expandDic(dic, func(string) {}); err == nil {
+			t.Errorf("dic %q: expected an error", dic)
+		}
+	}
+}
+
+func TestLoadHunspell(t *testing.T) {
+	dir := t.TempDir()
+	affPath := filepath.Join(dir, "test.aff")
+	dicPath := filepath.Join(dir, "test.dic")
+	aff := "SET UTF-8\nSFX S Y 1\nSFX S 0 s .\n"
+	dic := "3\nParis\nword/S\nWord\n"
+	if err := ioutil.WriteFile(affPath, []byte(aff), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(dicPath, []byte(dic), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[bool][]string{
+		false: {"Paris", "Word", "word", "words"},
+		true:  {"paris", "word", "words"},
+	}
+	for ignoreCase, words := range expected {
+		d, err := LoadHunspell(dicPath, affPath, DictionaryUnigram, ignoreCase)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(d.Words, words) {
+			t.Errorf("ignoreCase=%v: expected %q but got %q", ignoreCase, words, d.Words)
+		}
+	}
+
+	if _, err := LoadHunspell(dicPath, filepath.Join(dir, "missing.aff"), DictionaryUnigram,
+		false); err == nil {
+		t.Error("no error for missing .aff file")
+	}
+}
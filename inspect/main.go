@@ -0,0 +1,79 @@
+// Command inspect prints information about a trained
+// model, such as the out-of-bag statistics of a Forest
+// or the architecture of an RNN.
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/unixpickle/serializer"
+	"github.com/unixpickle/spacesplice"
+)
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintln(os.Stderr, "Usage: inspect <model file>")
+		os.Exit(1)
+	}
+	modelData, err := ioutil.ReadFile(os.Args[1])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to read model:", err)
+		os.Exit(1)
+	}
+	model, err := serializer.DeserializeWithType(modelData)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to deserialize model:", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Model type: %T\n", model)
+	switch model := model.(type) {
+	case *spacesplice.Forest:
+		inspectForest(model)
+	case *spacesplice.Dictionary:
+		fmt.Println("Words:", len(model.Words))
+		fmt.Println("Mode:", model.Mode)
+	case *spacesplice.Markov:
+		fmt.Println("Training words:", model.TotalCount)
+		fmt.Println("Fold case:", model.FoldCase)
+	case *spacesplice.RNN:
+		fmt.Println("Architecture:", model.Architecture)
+		if model.Encoding == nil {
+			fmt.Println("Encoding: bytes")
+		} else {
+			fmt.Printf("Encoding: %d runes, %d hash buckets\n", len(model.Encoding.Runes),
+				model.Encoding.HashBuckets)
+		}
+	}
+}
+
+func inspectForest(f *spacesplice.Forest) {
+	features := f.Features()
+	fmt.Println("Trees:", f.NumTrees())
+	fmt.Println("Cutoff:", f.Cutoff())
+	fmt.Printf("Window: -%d to +%d\n", features.Before, features.After)
+	fmt.Printf("Features: bytes=%v classes=%v transitions=%v words=%v\n",
+		features.Bytes, features.Classes, features.Transitions, features.KnownWords)
+
+	stats := f.Stats()
+	if stats == nil {
+		fmt.Println("No out-of-bag statistics (model predates them).")
+		return
+	}
+	fmt.Printf("Out-of-bag error: %.4f\n", stats.OOBError)
+	fmt.Println()
+	fmt.Println("Permutation importance:")
+	var names []string
+	for name := range stats.Importance {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return stats.Importance[names[i]] > stats.Importance[names[j]]
+	})
+	for _, name := range names {
+		fmt.Printf("  %-16s %.4f\n", name, stats.Importance[name])
+	}
+}
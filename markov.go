@@ -1,52 +1,100 @@
 package spacesplice
 
 import (
-	"encoding/json"
+	"errors"
 	"math"
 	"strings"
 )
 
 var markovSingleLetterWords = []string{"a", "I"}
 
-const maxWordLen = 20
+const (
+	maxWordLen = 20
+
+	// markovDefaultDiscount is used as a Kneser-Ney
+	// discount when there are not enough counts to
+	// estimate one.
+	markovDefaultDiscount = 0.75
+
+	// markovUnknownStop is the probability that an unseen
+	// word ends after any given character.
+	markovUnknownStop = 0.2
+
+	// markovUnknownAlphabet is the number of characters
+	// which are assumed to be equally likely in an unseen
+	// word.
+	markovUnknownAlphabet = 32
+)
 
 // Markov is a Splicer that uses a simple Markov chain
 // to insert spaces into a piece of text.
+//
+// Words are interned to integer IDs, and bigram counts
+// are stored in a hash table keyed by pairs of IDs.
 type Markov struct {
+	// FoldCase is true if the model's statistics are
+	// case-insensitive, in which case words are converted
+	// to lower case before being looked up.
+	FoldCase bool
+
+	// TotalCount is the number of words in the training
+	// corpus.
 	TotalCount int
 
-	// RawCounts stores the number of occurrences of each
-	// word in the training corpus. It can be thought of
-	// as a frequency map of underlying words.
-	RawCounts map[string]int
+	// Discount is the absolute discount subtracted from
+	// every bigram count.
+	Discount float64
+
+	// UnigramDiscount is the absolute discount subtracted
+	// from every continuation count and raw count to make
+	// room for unseen words.
+	UnigramDiscount float64
 
-	// Table maps words to tables indicating the likelihood
-	// of each possible following word. It can be thought of
-	// as the frequency map of words given that a different
-	// word was just seen.
-	Table map[string]map[string]int
+	// Unknown scores words which were never seen during
+	// training.
+	// It may be nil for models trained before unknown
+	// words were modeled.
+	Unknown *UnknownWords
 
-	// TableCounts stores the total number of table entries
-	// for each word (i.e. it is the sum of the values for
-	// each key in each table). This may be different than
-	// RawCounts, since RawCounts includes occurrences at
-	// the very ends of training documents.
-	TableCounts map[string]int
+	// words maps IDs to words.
+	// ID 0 is always "", which marks the start of a
+	// document.
+	words []string
+	ids   map[string]int
+
+	// counts stores the number of occurrences of each
+	// word in the training corpus.
+	counts []int
+
+	// bigrams maps pairs of IDs (see markovBigramKey) to
+	// the number of times the second word followed the
+	// first.
+	bigrams map[uint64]int
+
+	// The following are derived from bigrams:
+	//
+	// tableCounts stores the number of times each word
+	// was followed by another word.
+	// followers stores the number of distinct words
+	// which followed each word.
+	// continuations stores the number of distinct words
+	// which each word followed.
+	// trie stores the vocabulary for enumerating known
+	// words longer than maxWordLen.
+	tableCounts       []int
+	followers         []int
+	continuations     []int
+	continuationTotal int
+	trie              *trie
 }
 
 // TrainMarkov trains a Markov model on a directory full
 // of sample text files.
-func TrainMarkov(corpusDir string) (*Markov, error) {
-	res := &Markov{
-		RawCounts:   map[string]int{},
-		Table:       map[string]map[string]int{},
-		TableCounts: map[string]int{},
-	}
-	err := ReadSamples(corpusDir, func(sampleBody []byte) {
-		fields := strings.Fields(string(sampleBody))
-		trainMarkovSample(res, fields)
-	})
-	if err != nil {
+// If ignoreCase is true, the model ignores case, so that
+// "Wikipedia" and "wikipedia" are the same word.
+func TrainMarkov(corpusDir string, ignoreCase bool) (*Markov, error) {
+	res := newMarkov(ignoreCase)
+	if err := res.Update(corpusDir); err != nil {
 		return nil, err
 	}
 	return res, nil
@@ -54,105 +102,174 @@ func TrainMarkov(corpusDir string) (*Markov, error) {
 
 // DeserializeMarkov deserializes a Markov model which
 // was serialized with Markov.Serialize().
+//
+// Models which were serialized as JSON by older versions
+// of this package are also supported.
 func DeserializeMarkov(d []byte) (*Markov, error) {
-	var res Markov
-	if err := json.Unmarshal(d, &res); err != nil {
-		return nil, err
+	if len(d) > 0 && d[0] == '{' {
+		return deserializeMarkovJSON(d)
 	}
-	return &res, nil
+	return decodeMarkov(d)
 }
 
 // CondProb returns the conditional probability of a
-// word given the previous word. It returns 0 if the
-// word has never been seen after the previous word.
+// word given the previous word.
+// If m.FoldCase is set, the words are converted to lower
+// case first.
+//
+// The probability is smoothed with interpolated
+// Kneser-Ney, backing off to a continuation unigram
+// distribution and then to a distribution over unseen
+// words, so it is non-zero for every word.
 // It returns 1 if the word is "".
 func (m *Markov) CondProb(previous, word string) float64 {
+	if m.FoldCase {
+		return m.condProb(foldCase(previous), foldCase(word))
+	}
+	return m.condProb(previous, word)
+}
+
+// condProb implements CondProb for words which have
+// already been case-folded if necessary.
+func (m *Markov) condProb(previous, word string) float64 {
 	if word == "" {
 		return 1
 	}
-	prevMap := m.Table[previous]
-	if prevMap == nil {
-		return 0
+	wordID, wordOk := m.ids[word]
+	lowerOrder := m.continuationProb(wordID, wordOk, word)
+	prevID, ok := m.ids[previous]
+	if !ok || m.tableCounts[prevID] == 0 {
+		return lowerOrder
+	}
+	var count int
+	if wordOk {
+		count = m.bigrams[markovBigramKey(prevID, wordID)]
 	}
-	nextCount := m.TableCounts[previous]
-	return float64(prevMap[word]) / float64(nextCount)
+	discounted := math.Max(float64(count)-m.Discount, 0)
+	backoffMass := m.Discount * float64(m.followers[prevID])
+	return (discounted + backoffMass*lowerOrder) / float64(m.tableCounts[prevID])
 }
 
 // Prob returns the unconditional probability of a word.
-// It returns 0 if the word has never been seen before.
+// If m.FoldCase is set, the word is converted to lower
+// case first.
+//
+// The raw word counts are smoothed with absolute
+// discounting, so the probability is non-zero even for
+// words which have never been seen.
 // It returns 1 if the word is "".
 func (m *Markov) Prob(word string) float64 {
 	if word == "" {
 		return 1
 	}
-	return float64(m.RawCounts[word]) / float64(m.TotalCount)
+	if m.FoldCase {
+		word = foldCase(word)
+	}
+	if m.TotalCount == 0 {
+		return m.unknownProb(word)
+	}
+	var count int
+	if id, ok := m.ids[word]; ok {
+		count = m.counts[id]
+	}
+	discounted := math.Max(float64(count)-m.UnigramDiscount, 0)
+	backoffMass := m.UnigramDiscount * float64(len(m.words)-1)
+	return (discounted + backoffMass*m.unknownProb(word)) / float64(m.TotalCount)
+}
+
+// Update trains the model further on a directory full of
+// sample text files, adding to its existing counts.
+func (m *Markov) Update(corpusDir string) error {
+	err := ReadSamples(corpusDir, func(sampleBody []byte) {
+		fields := Tokenize(string(sampleBody))
+		trainMarkovSample(m, fields)
+	})
+	if err != nil {
+		return err
+	}
+	m.countsChanged()
+	return nil
+}
+
+// Merge adds the counts from another model to m, giving
+// the same result as training one model on both corpora.
+// Both models must have the same FoldCase setting.
+func (m *Markov) Merge(other *Markov) error {
+	if m.FoldCase != other.FoldCase {
+		return errors.New("cannot merge case-sensitive and case-insensitive models")
+	}
+	idMap := make([]int, len(other.words))
+	for id, word := range other.words {
+		idMap[id] = m.intern(word)
+		m.counts[idMap[id]] += other.counts[id]
+	}
+	m.TotalCount += other.TotalCount
+	for key, count := range other.bigrams {
+		prev, word := markovBigramIDs(key)
+		m.bigrams[markovBigramKey(idMap[prev], idMap[word])] += count
+	}
+	m.countsChanged()
+	return nil
 }
 
 // BestField returns the most likely next field in the
 // string given the previous field.
+// Unlike Fields, this only looks two words ahead.
 //
 // The previous field "" means this is the first field.
 func (m *Markov) BestField(previous string, str string) string {
-	var bestCond float64
-	var bestCondStr string
-	var bestUncond float64
-	var bestUncondStr string
-
-	followingPairs(str, func(w1, w2 string) {
-		condProb := m.CondProb(previous, w1) * m.CondProb(w1, w2)
-		if condProb > bestCond {
-			bestCond = condProb
-			bestCondStr = w1
-		}
-		uncondProb := m.Prob(w1) * m.Prob(w2)
-		if uncondProb > bestUncond {
-			bestUncond = uncondProb
-			bestUncondStr = w1
-		}
-	})
-
-	if bestCond > 0 {
-		return bestCondStr
-	} else if bestUncond > 0 {
-		return bestUncondStr
-	}
-
-	followingWords(str, func(w1 string) {
-		condProb := m.CondProb(previous, w1)
-		if condProb > bestCond {
-			bestCond = condProb
-			bestCondStr = w1
-		}
-	})
-
-	if bestCond > 0 {
-		return bestCondStr
+	if m.FoldCase {
+		return str[:len(m.bestField(foldCase(previous), foldCase(str)))]
 	}
+	return m.bestField(previous, str)
+}
 
+// bestField implements BestField for a string which has
+// already been case-folded if necessary.
+func (m *Markov) bestField(previous string, str string) string {
+	var bestProb float64
+	var bestStr string
 	followingPairs(str, func(w1, w2 string) {
-		uncondProb := math.Max(m.Prob(w1), m.Prob(w2))
-		if uncondProb >= bestUncond {
-			bestUncond = uncondProb
-			bestUncondStr = w1
+		prob := m.condProb(previous, w1) * m.condProb(w1, w2)
+		if prob > bestProb || bestStr == "" {
+			bestProb = prob
+			bestStr = w1
 		}
 	})
-
-	return bestUncondStr
+	return bestStr
 }
 
 // Fields uses the Markov model to split the spaceless
 // text into fields (i.e. words).
+//
+// Each whitespace-separated part of the text is split
+// with the Viterbi algorithm, yielding the sequence of
+// words with the greatest overall probability.
 func (m *Markov) Fields(text string) []string {
-	parts := strings.Fields(text)
 	var res []string
-	for _, part := range parts {
-		var lastWord string
-		for i := 0; i < len(part); i += len(lastWord) {
-			lastWord = m.BestField(lastWord, part[i:])
-			res = append(res, lastWord)
+	for _, part := range strings.Fields(text) {
+		if m.FoldCase {
+			res = append(res, splitLike(part, m.viterbi(foldCase(part)))...)
+		} else {
+			res = append(res, m.viterbi(part)...)
 		}
 	}
-	return res
+	return joinPunctuation(res)
+}
+
+// NBestFields returns the n most likely segmentations of
+// the text, along with their log-probabilities.
+func (m *Markov) NBestFields(text string, n int) []Segmentation {
+	return nbestFields(text, n, func(part string) []Segmentation {
+		if !m.FoldCase {
+			return m.nbestSegmentations(part, n)
+		}
+		res := m.nbestSegmentations(foldCase(part), n)
+		for i, seg := range res {
+			res[i].Fields = splitLike(part, seg.Fields)
+		}
+		return res
+	})
 }
 
 // SerializerType returns the unique ID used to
@@ -162,38 +279,177 @@ func (m *Markov) SerializerType() string {
 	return serializerTypeMarkov
 }
 
-// Serialize serializes the Markov model.
+// Serialize serializes the Markov model in a compact
+// binary format.
 func (m *Markov) Serialize() ([]byte, error) {
-	return json.Marshal(m)
+	return m.encode()
 }
 
 func trainMarkovSample(m *Markov, sampleFields []string) {
-	var lastWord string
+	var lastID int
 	for _, field := range sampleFields {
-		if len(field) == 1 {
-			var allowed bool
-			for _, x := range markovSingleLetterWords {
-				if x == field {
-					allowed = true
-				}
-			}
-			if !allowed {
-				continue
-			}
+		if !markovAllowedField(field) {
+			continue
 		}
+		if m.FoldCase {
+			field = foldCase(field)
+		}
+		id := m.intern(field)
 		m.TotalCount++
-		m.RawCounts[field]++
-		m.TableCounts[lastWord]++
-		subTable := m.Table[lastWord]
-		if subTable == nil {
-			subTable = map[string]int{}
-			m.Table[lastWord] = subTable
+		m.counts[id]++
+		m.bigrams[markovBigramKey(lastID, id)]++
+		lastID = id
+	}
+}
+
+// viterbi finds the most likely segmentation of str.
+func (m *Markov) viterbi(str string) []string {
+	return m.nbestSegmentations(str, 1)[0].Fields
+}
+
+// nbestSegmentations finds the n most likely
+// segmentations of str with a k-best Viterbi search.
+func (m *Markov) nbestSegmentations(str string, n int) []Segmentation {
+	return nbestSegmentations(str, n, m.candidates, m.logProb)
+}
+
+// candidates enumerates the words which may start str.
+func (m *Markov) candidates(str string, f func(word string)) {
+	followingCandidates(m.trie, str, f)
+}
+
+// logProb returns the log-likelihood of a word given the
+// previous word.
+func (m *Markov) logProb(previous, word string) float64 {
+	return math.Log(m.condProb(previous, word))
+}
+
+// continuationProb returns the lower-order Kneser-Ney
+// probability of a word, which is proportional to the
+// number of distinct words it has followed.
+func (m *Markov) continuationProb(id int, known bool, word string) float64 {
+	if m.continuationTotal == 0 {
+		return m.unknownProb(word)
+	}
+	var count int
+	if known {
+		count = m.continuations[id]
+	}
+	discounted := math.Max(float64(count)-m.UnigramDiscount, 0)
+	backoffMass := m.UnigramDiscount * float64(len(m.words)-1)
+	return (discounted + backoffMass*m.unknownProb(word)) /
+		float64(m.continuationTotal)
+}
+
+// unknownProb returns the probability of a word under
+// the model of unseen words.
+func (m *Markov) unknownProb(word string) float64 {
+	if m.Unknown == nil {
+		return markovUnknownProb(word)
+	}
+	return m.Unknown.Prob(word)
+}
+
+// vocab returns every word seen during training.
+func (m *Markov) vocab() []string {
+	return append([]string{}, m.words[1:]...)
+}
+
+// intern returns the ID for a word, adding the word to
+// the vocabulary if necessary.
+func (m *Markov) intern(word string) int {
+	if id, ok := m.ids[word]; ok {
+		return id
+	}
+	id := len(m.words)
+	m.ids[word] = id
+	m.words = append(m.words, word)
+	m.counts = append(m.counts, 0)
+	return id
+}
+
+// countsChanged updates the smoothing parameters and the
+// model of unseen words after the counts change.
+func (m *Markov) countsChanged() {
+	m.computeDerived()
+	m.estimateDiscounts()
+	m.Unknown = NewUnknownWords(m.vocab())
+}
+
+// computeDerived computes the per-word statistics that
+// can be derived from the bigram counts.
+func (m *Markov) computeDerived() {
+	m.tableCounts = make([]int, len(m.words))
+	m.followers = make([]int, len(m.words))
+	m.continuations = make([]int, len(m.words))
+	m.continuationTotal = len(m.bigrams)
+	for key, count := range m.bigrams {
+		prev, word := markovBigramIDs(key)
+		m.tableCounts[prev] += count
+		m.followers[prev]++
+		m.continuations[word]++
+	}
+	m.trie = newTrie(m.vocab())
+}
+
+// estimateDiscounts estimates the Kneser-Ney discounts
+// from the count-of-counts of the training data.
+func (m *Markov) estimateDiscounts() {
+	var bigramCounts [3]int
+	for _, count := range m.bigrams {
+		if count < len(bigramCounts) {
+			bigramCounts[count]++
+		}
+	}
+	var continuationCounts [3]int
+	for _, count := range m.continuations {
+		if count < len(continuationCounts) {
+			continuationCounts[count]++
+		}
+	}
+	m.Discount = markovDiscount(bigramCounts[1], bigramCounts[2])
+	m.UnigramDiscount = markovDiscount(continuationCounts[1], continuationCounts[2])
+}
+
+// markovAllowedField returns false for single-letter
+// fields which are unlikely to be real words, since they
+// would teach a model to split words apart.
+// Punctuation tokens are always allowed.
+func markovAllowedField(field string) bool {
+	if len(field) != 1 || !isWordRune(rune(field[0])) {
+		return true
+	}
+	for _, x := range markovSingleLetterWords {
+		if strings.EqualFold(x, field) {
+			return true
 		}
-		subTable[field]++
-		lastWord = field
+	}
+	return false
+}
+
+// newMarkov creates an empty Markov model.
+func newMarkov(foldCase bool) *Markov {
+	return &Markov{
+		FoldCase: foldCase,
+		words:    []string{""},
+		ids:      map[string]int{"": 0},
+		counts:   []int{0},
+		bigrams:  map[uint64]int{},
 	}
 }
 
+// markovBigramKey packs a pair of word IDs into a key
+// for Markov.bigrams.
+func markovBigramKey(prev, word int) uint64 {
+	return uint64(prev)<<32 | uint64(word)
+}
+
+// markovBigramIDs unpacks a key created with
+// markovBigramKey.
+func markovBigramIDs(key uint64) (prev, word int) {
+	return int(key >> 32), int(key & 0xffffffff)
+}
+
 func followingPairs(str string, f func(w1, w2 string)) {
 	followingWords(str, func(w1 string) {
 		if len(w1) == len(str) {
@@ -211,3 +467,22 @@ func followingWords(str string, f func(string)) {
 		f(str[:l])
 	}
 }
+
+// markovDiscount estimates an absolute discount from the
+// number of items seen exactly once and exactly twice.
+func markovDiscount(n1, n2 int) float64 {
+	if n1 == 0 || n2 == 0 {
+		return markovDefaultDiscount
+	}
+	return float64(n1) / float64(n1+2*n2)
+}
+
+// markovUnknownProb returns the probability of a word
+// under a simple model of unseen words, which draws
+// characters uniformly until stopping at random.
+// It is used by models which have no UnknownWords.
+func markovUnknownProb(word string) float64 {
+	length := float64(len(word))
+	return markovUnknownStop * math.Pow(1-markovUnknownStop, length-1) *
+		math.Pow(1.0/markovUnknownAlphabet, length)
+}
@@ -0,0 +1,209 @@
+package spacesplice
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"sort"
+)
+
+const (
+	markovMagic   = "SSMK"
+	markovVersion = 1
+
+	markovFlagFoldCase = 1
+)
+
+// encode serializes the model in a compact binary format.
+//
+// The format consists of a header, the vocabulary with
+// the count of each word, and the bigram counts sorted
+// by ID and delta-encoded.
+// All integers are unsigned varints.
+//
+// The model of unseen words is not stored, since it is
+// rebuilt from the vocabulary when the model is decoded.
+func (m *Markov) encode() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(markovMagic)
+	writeUvarint(&buf, markovVersion)
+
+	var flags uint64
+	if m.FoldCase {
+		flags |= markovFlagFoldCase
+	}
+	writeUvarint(&buf, flags)
+	writeUvarint(&buf, math.Float64bits(m.Discount))
+	writeUvarint(&buf, math.Float64bits(m.UnigramDiscount))
+
+	writeUvarint(&buf, uint64(len(m.words)-1))
+	for id, word := range m.words[1:] {
+		writeString(&buf, word)
+		writeUvarint(&buf, uint64(m.counts[id+1]))
+	}
+
+	keys := make([]uint64, 0, len(m.bigrams))
+	for key := range m.bigrams {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i] < keys[j]
+	})
+	writeUvarint(&buf, uint64(len(keys)))
+	var lastPrev, lastWord int
+	for _, key := range keys {
+		prev, word := markovBigramIDs(key)
+		writeUvarint(&buf, uint64(prev-lastPrev))
+		if prev == lastPrev {
+			writeUvarint(&buf, uint64(word-lastWord))
+		} else {
+			writeUvarint(&buf, uint64(word))
+		}
+		writeUvarint(&buf, uint64(m.bigrams[key]))
+		lastPrev, lastWord = prev, word
+	}
+
+	return buf.Bytes(), nil
+}
+
+// decodeMarkov decodes a model which was serialized with
+// Markov.encode().
+func decodeMarkov(d []byte) (res *Markov, err error) {
+	defer func() {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+	}()
+
+	if !bytes.HasPrefix(d, []byte(markovMagic)) {
+		return nil, errors.New("invalid Markov data")
+	}
+	r := bufio.NewReader(bytes.NewReader(d[len(markovMagic):]))
+	version, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	} else if version != markovVersion {
+		return nil, errors.New("unsupported Markov version")
+	}
+
+	header := make([]uint64, 3)
+	for i := range header {
+		if header[i], err = binary.ReadUvarint(r); err != nil {
+			return nil, err
+		}
+	}
+	res = newMarkov(header[0]&markovFlagFoldCase != 0)
+	res.Discount = math.Float64frombits(header[1])
+	res.UnigramDiscount = math.Float64frombits(header[2])
+
+	numWords, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < numWords; i++ {
+		word, err := readString(r)
+		if err != nil {
+			return nil, err
+		}
+		count, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		id := res.intern(word)
+		res.counts[id] = int(count)
+		res.TotalCount += int(count)
+	}
+
+	numBigrams, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	var prev, word int
+	for i := uint64(0); i < numBigrams; i++ {
+		var fields [3]uint64
+		for j := range fields {
+			if fields[j], err = binary.ReadUvarint(r); err != nil {
+				return nil, err
+			}
+		}
+		// Bounding the deltas first keeps corrupt values
+		// from overflowing when they are converted to int.
+		if fields[0] > uint64(len(res.words)) || fields[1] > uint64(len(res.words)) {
+			return nil, errors.New("invalid Markov word ID")
+		}
+		if fields[0] == 0 {
+			word += int(fields[1])
+		} else {
+			prev += int(fields[0])
+			word = int(fields[1])
+		}
+		if prev < 0 || word < 0 || prev >= len(res.words) || word >= len(res.words) {
+			return nil, errors.New("invalid Markov word ID")
+		}
+		res.bigrams[markovBigramKey(prev, word)] = int(fields[2])
+	}
+
+	res.computeDerived()
+	res.Unknown = NewUnknownWords(res.vocab())
+	return res, nil
+}
+
+// markovJSON is the format in which Markov models were
+// serialized before they were stored by ID.
+type markovJSON struct {
+	FoldCase        bool
+	RawCounts       map[string]int
+	Table           map[string]map[string]int
+	Continuations   map[string]int
+	Discount        float64
+	UnigramDiscount float64
+	Unknown         *UnknownWords
+}
+
+func deserializeMarkovJSON(d []byte) (*Markov, error) {
+	var obj markovJSON
+	if err := json.Unmarshal(d, &obj); err != nil {
+		return nil, err
+	}
+	res := newMarkov(obj.FoldCase)
+	res.Discount = obj.Discount
+	res.UnigramDiscount = obj.UnigramDiscount
+	res.Unknown = obj.Unknown
+	if res.Unknown != nil {
+		res.Unknown.computeTotals()
+	}
+
+	words := make([]string, 0, len(obj.RawCounts))
+	for word := range obj.RawCounts {
+		words = append(words, word)
+	}
+	sort.Strings(words)
+	for _, word := range words {
+		id := res.intern(word)
+		res.counts[id] = obj.RawCounts[word]
+		res.TotalCount += obj.RawCounts[word]
+	}
+	for prev, subTable := range obj.Table {
+		prevID := res.intern(prev)
+		for word, count := range subTable {
+			res.bigrams[markovBigramKey(prevID, res.intern(word))] = count
+		}
+	}
+
+	res.computeDerived()
+	if obj.Continuations == nil {
+		// Models from before smoothing was supported.
+		res.estimateDiscounts()
+	}
+	return res, nil
+}
+
+func writeUvarint(w *bytes.Buffer, x uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], x)
+	w.Write(buf[:n])
+}
@@ -0,0 +1,227 @@
+package spacesplice
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestMarkovBestField(t *testing.T) {
+	for _, ignoreCase := range []bool{false, true} {
+		m, err := TrainMarkov(testCorpusDir(t), ignoreCase)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual := m.BestField("", "thedocumentation"); actual != "the" {
+			t.Errorf("ignoreCase=%v: expected \"the\" but got %q", ignoreCase, actual)
+		}
+	}
+
+	m, err := TrainMarkov(testCorpusDir(t), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := m.BestField("The", "DOCUMENTATIONconsists"); actual != "DOCUMENTATION" {
+		t.Errorf("expected \"DOCUMENTATION\" but got %q", actual)
+	}
+}
+
+func TestMarkovFoldCase(t *testing.T) {
+	m, err := TrainMarkov(testCorpusDir(t), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.CondProb("The", "Documentation") != m.CondProb("the", "documentation") {
+		t.Error("CondProb depends on case")
+	}
+	if m.Prob("MODEL") != m.Prob("model") {
+		t.Error("Prob depends on case")
+	}
+}
+
+func TestMarkovMerge(t *testing.T) {
+	extra := "Merging models adds new words, like segmentation and merging, to the model."
+	expected, err := TrainMarkov(testCorpusDir(t, append(testCorpus, extra)...), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	merged, err := TrainMarkov(testCorpusDir(t), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := TrainMarkov(testCorpusDir(t, extra), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := merged.Merge(other); err != nil {
+		t.Fatal(err)
+	}
+	testMarkovEqual(t, expected, merged)
+
+	updated, err := TrainMarkov(testCorpusDir(t), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := updated.Update(testCorpusDir(t, extra)); err != nil {
+		t.Fatal(err)
+	}
+	testMarkovEqual(t, expected, updated)
+
+	for _, word := range []string{"merging", "model", "xqzt"} {
+		if p1, p2 := expected.Prob(word), merged.Prob(word); p1 != p2 {
+			t.Errorf("Prob(%q): expected %v but got %v", word, p1, p2)
+		}
+	}
+}
+
+func TestMarkovMergeFoldCase(t *testing.T) {
+	dir := testCorpusDir(t)
+	m1, err := TrainMarkov(dir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m2, err := TrainMarkov(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m1.Merge(m2); err == nil {
+		t.Error("no error for merging models with different FoldCase settings")
+	}
+}
+
+func TestMarkovFields(t *testing.T) {
+	m, err := TrainMarkov(testCorpusDir(t), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"The", "documentation", "consists", "of", "two", "parts."}
+	actual := m.Fields("Thedocumentationconsistsoftwoparts.")
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %q but got %q", expected, actual)
+	}
+
+	segs := m.NBestFields("Thedocumentationconsistsoftwoparts.", 5)
+	// Segmentations which only differ around punctuation
+	// are merged, so there may be fewer than requested.
+	if len(segs) < 2 || len(segs) > 5 {
+		t.Fatalf("expected 2 to 5 segmentations but got %d", len(segs))
+	}
+	if !reflect.DeepEqual(segs[0].Fields, expected) {
+		t.Errorf("expected best segmentation %q but got %q", expected, segs[0].Fields)
+	}
+	var totalConfidence float64
+	for i, seg := range segs {
+		if i > 0 && seg.LogProb > segs[i-1].LogProb {
+			t.Errorf("segmentation %d is more likely than segmentation %d", i, i-1)
+		}
+		totalConfidence += seg.Confidence
+	}
+	if math.Abs(totalConfidence-1) > 1e-8 {
+		t.Errorf("confidences sum to %f", totalConfidence)
+	}
+}
+
+func TestMarkovEncoding(t *testing.T) {
+	m, err := TrainMarkov(testCorpusDir(t), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := m.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DeserializeMarkov(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testMarkovEqual(t, m, decoded)
+
+	reencoded, err := decoded.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, reencoded) {
+		t.Error("re-encoding the model changed its data")
+	}
+}
+
+func TestMarkovEncodingCorrupt(t *testing.T) {
+	m, err := TrainMarkov(testCorpusDir(t), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := m.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < len(data); i++ {
+		if _, err := DeserializeMarkov(data[:i]); err == nil {
+			t.Errorf("no error for data truncated to %d bytes", i)
+		}
+	}
+
+	var huge bytes.Buffer
+	huge.WriteString(markovMagic)
+	for _, x := range []uint64{markovVersion, 0, 0, 0, 1, 1 << 62} {
+		writeUvarint(&huge, x)
+	}
+	if _, err := DeserializeMarkov(huge.Bytes()); err == nil {
+		t.Error("no error for huge word length")
+	}
+
+	var hugeID bytes.Buffer
+	hugeID.WriteString(markovMagic)
+	for _, x := range []uint64{markovVersion, 0, 0, 0, 1, 1, 'a', 1, 1, 1 << 63, 0, 1} {
+		writeUvarint(&hugeID, x)
+	}
+	if _, err := DeserializeMarkov(hugeID.Bytes()); err == nil {
+		t.Error("no error for huge word ID delta")
+	}
+
+	gen := rand.New(rand.NewSource(1337))
+	for i := 0; i < 1000; i++ {
+		corrupt := append([]byte{}, data...)
+		for j := 0; j < 4; j++ {
+			corrupt[gen.Intn(len(corrupt))] = byte(gen.Intn(256))
+		}
+		// Corrupt data may still decode, but it must not
+		// cause a panic.
+		DeserializeMarkov(corrupt)
+	}
+}
+
+func TestMarkovLegacyJSON(t *testing.T) {
+	legacy := []byte(`{"FoldCase":false,"RawCounts":{"the":2,"cat":1,"sat":1},` +
+		`"Table":{"":{"the":1},"the":{"cat":1},"cat":{"sat":1},"sat":{"the":1}}}`)
+	m, err := DeserializeMarkov(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.TotalCount != 4 || m.Discount == 0 {
+		t.Errorf("unexpected model: TotalCount=%d Discount=%f", m.TotalCount, m.Discount)
+	}
+	expected := []string{"the", "cat", "sat"}
+	if actual := m.Fields("thecatsat"); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %q but got %q", expected, actual)
+	}
+}
+
+func testMarkovEqual(t *testing.T, expected, actual *Markov) {
+	if expected.FoldCase != actual.FoldCase || expected.TotalCount != actual.TotalCount ||
+		expected.Discount != actual.Discount ||
+		expected.UnigramDiscount != actual.UnigramDiscount {
+		t.Errorf("expected %+v but got %+v", expected, actual)
+	}
+	pairs := [][2]string{{"", "the"}, {"the", "documentation"}, {"of", "two"},
+		{"the", "unseen"}, {"", "xqzt"}, {"and", "merging"}}
+	for _, pair := range pairs {
+		p1 := expected.CondProb(pair[0], pair[1])
+		p2 := actual.CondProb(pair[0], pair[1])
+		if p1 != p2 {
+			t.Errorf("CondProb(%q, %q): expected %v but got %v", pair[0], pair[1], p1, p2)
+		}
+	}
+}
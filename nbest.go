@@ -0,0 +1,214 @@
+package spacesplice
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+// A Segmentation is one possible way of splitting a
+// piece of text into fields.
+type Segmentation struct {
+	Fields []string
+
+	// LogProb is the log-probability of the segmentation.
+	// Models which are not probabilistic use a negated
+	// cost instead, so that greater is still better.
+	LogProb float64
+
+	// Confidence is the probability of the segmentation
+	// relative to the other segmentations it was returned
+	// with.
+	Confidence float64
+}
+
+// An NBestFielder is a Fielder which can produce several
+// alternative segmentations of a piece of text, which is
+// useful when the model is unsure of the answer.
+type NBestFielder interface {
+	Fielder
+
+	// NBestFields returns up to n segmentations of the
+	// text, sorted from most to least likely.
+	NBestFields(text string, n int) []Segmentation
+}
+
+// nbestFields computes the n best segmentations of a
+// text by splitting each whitespace-separated part with
+// splitPart and combining the results.
+// Fields are passed through joinPunctuation, and any
+// duplicate segmentations this creates are removed.
+func nbestFields(text string, n int, splitPart func(part string) []Segmentation) []Segmentation {
+	res := []Segmentation{{}}
+	for _, part := range strings.Fields(text) {
+		segs := splitPart(part)
+		var combined []Segmentation
+		for _, prefix := range res {
+			for _, seg := range segs {
+				fields := append(append([]string{}, prefix.Fields...), seg.Fields...)
+				combined = append(combined, Segmentation{
+					Fields:  fields,
+					LogProb: prefix.LogProb + seg.LogProb,
+				})
+			}
+		}
+		sortSegmentations(combined)
+		if len(combined) > n {
+			combined = combined[:n]
+		}
+		res = combined
+	}
+
+	var deduped []Segmentation
+	seen := map[string]bool{}
+	for _, seg := range res {
+		seg.Fields = joinPunctuation(seg.Fields)
+		key := strings.Join(seg.Fields, " ")
+		if !seen[key] {
+			seen[key] = true
+			deduped = append(deduped, seg)
+		}
+	}
+	setConfidences(deduped)
+	return deduped
+}
+
+// nbestSegmentations finds the n segmentations of str
+// with the greatest total score.
+//
+// The candidates function enumerates the words which may
+// start a string, and the score function scores a word
+// given the word before it ("" at the start of str).
+func nbestSegmentations(str string, n int, candidates func(str string, f func(word string)),
+	score func(previous, word string) float64) []Segmentation {
+	lattice := make([][]*segmentState, len(str)+1)
+	lattice[0] = []*segmentState{{paths: []segmentPath{{}}}}
+	for end := 0; end < len(str); end++ {
+		if len(lattice[end]) == 0 {
+			continue
+		}
+		// The candidates only depend on the position, so
+		// they are shared by every state which ends here.
+		var words []string
+		candidates(str[end:], func(word string) {
+			words = append(words, word)
+		})
+		for _, word := range words {
+			next := end + len(word)
+			var target *segmentState
+			for _, s := range lattice[next] {
+				if s.start == end {
+					target = s
+					break
+				}
+			}
+			if target == nil {
+				target = &segmentState{start: end}
+				lattice[next] = append(lattice[next], target)
+			}
+			for _, state := range lattice[end] {
+				wordScore := score(str[state.start:end], word)
+				for rank, path := range state.paths {
+					target.add(segmentPath{
+						logProb:  path.logProb + wordScore,
+						prev:     state,
+						prevRank: rank,
+					}, n)
+				}
+			}
+		}
+	}
+
+	type finalPath struct {
+		state *segmentState
+		rank  int
+	}
+	var finals []finalPath
+	for _, state := range lattice[len(str)] {
+		for rank := range state.paths {
+			finals = append(finals, finalPath{state, rank})
+		}
+	}
+	sort.SliceStable(finals, func(i, j int) bool {
+		return finals[i].state.paths[finals[i].rank].logProb >
+			finals[j].state.paths[finals[j].rank].logProb
+	})
+	if len(finals) > n {
+		finals = finals[:n]
+	}
+
+	res := make([]Segmentation, len(finals))
+	for i, final := range finals {
+		res[i].LogProb = final.state.paths[final.rank].logProb
+		end := len(str)
+		for state, rank := final.state, final.rank; end > 0; {
+			res[i].Fields = append(res[i].Fields, str[state.start:end])
+			end = state.start
+			state, rank = state.paths[rank].prev, state.paths[rank].prevRank
+		}
+		reverseStrings(res[i].Fields)
+	}
+	return res
+}
+
+// segmentState is a node in the lattice used by
+// nbestSegmentations.
+// It stores the best paths ending with the word that
+// starts at index start.
+type segmentState struct {
+	start int
+	paths []segmentPath
+}
+
+// add inserts a path, keeping at most n paths sorted from
+// best to worst.
+func (s *segmentState) add(path segmentPath, n int) {
+	idx := sort.Search(len(s.paths), func(i int) bool {
+		return s.paths[i].logProb < path.logProb
+	})
+	if idx >= n {
+		return
+	}
+	s.paths = append(s.paths, segmentPath{})
+	copy(s.paths[idx+1:], s.paths[idx:])
+	s.paths[idx] = path
+	if len(s.paths) > n {
+		s.paths = s.paths[:n]
+	}
+}
+
+// segmentPath is a path through the lattice.
+// It continues the path with rank prevRank among the
+// paths of the previous state.
+type segmentPath struct {
+	logProb  float64
+	prev     *segmentState
+	prevRank int
+}
+
+func sortSegmentations(s []Segmentation) {
+	sort.SliceStable(s, func(i, j int) bool {
+		return s[i].LogProb > s[j].LogProb
+	})
+}
+
+// setConfidences normalizes the probabilities of the
+// segmentations so that they sum to 1.
+func setConfidences(s []Segmentation) {
+	if len(s) == 0 {
+		return
+	}
+	var total float64
+	for _, seg := range s {
+		total += math.Exp(seg.LogProb - s[0].LogProb)
+	}
+	for i, seg := range s {
+		s[i].Confidence = math.Exp(seg.LogProb-s[0].LogProb) / total
+	}
+}
+
+func reverseStrings(s []string) {
+	for i := 0; i < len(s)/2; i++ {
+		s[i], s[len(s)-i-1] = s[len(s)-i-1], s[i]
+	}
+}
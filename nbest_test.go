@@ -0,0 +1,101 @@
+package spacesplice
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestNBestSegmentations(t *testing.T) {
+	gen := rand.New(rand.NewSource(1337))
+	scores := map[string]float64{}
+	score := func(previous, word string) float64 {
+		key := previous + " " + word
+		if _, ok := scores[key]; !ok {
+			scores[key] = -gen.Float64() * 10
+		}
+		return scores[key]
+	}
+	candidates := func(str string, f func(word string)) {
+		for i := 1; i <= len(str) && i <= 3; i++ {
+			f(str[:i])
+		}
+	}
+
+	str := "abcdefg"
+	var all []Segmentation
+	var enumerate func(fields []string, rest string, logProb float64)
+	enumerate = func(fields []string, rest string, logProb float64) {
+		if rest == "" {
+			all = append(all, Segmentation{Fields: fields, LogProb: logProb})
+			return
+		}
+		previous := ""
+		if len(fields) > 0 {
+			previous = fields[len(fields)-1]
+		}
+		candidates(rest, func(word string) {
+			newFields := append(append([]string{}, fields...), word)
+			enumerate(newFields, rest[len(word):], logProb+score(previous, word))
+		})
+	}
+	enumerate(nil, str, 0)
+	sort.SliceStable(all, func(i, j int) bool {
+		return all[i].LogProb > all[j].LogProb
+	})
+
+	for _, n := range []int{1, 3, 10} {
+		actual := nbestSegmentations(str, n, candidates, score)
+		if len(actual) != n {
+			t.Fatalf("n=%d: got %d segmentations", n, len(actual))
+		}
+		for i, seg := range actual {
+			if math.Abs(seg.LogProb-all[i].LogProb) > 1e-8 {
+				t.Errorf("n=%d rank %d: expected log prob %f but got %f", n, i,
+					all[i].LogProb, seg.LogProb)
+			}
+			if !reflect.DeepEqual(seg.Fields, all[i].Fields) {
+				t.Errorf("n=%d rank %d: expected %q but got %q", n, i, all[i].Fields,
+					seg.Fields)
+			}
+		}
+	}
+}
+
+func TestNBestFieldsParts(t *testing.T) {
+	splitPart := func(part string) []Segmentation {
+		var res []Segmentation
+		for i := 1; i < len(part); i++ {
+			res = append(res, Segmentation{
+				Fields:  []string{part[:i], part[i:]},
+				LogProb: -float64(i),
+			})
+		}
+		return res
+	}
+	expected := []Segmentation{
+		{
+			Fields:     []string{"a", "bc", "d", "e"},
+			LogProb:    -2,
+			Confidence: 1 / (1 + math.Exp(-1)),
+		},
+		{
+			Fields:     []string{"ab", "c", "d", "e"},
+			LogProb:    -3,
+			Confidence: math.Exp(-1) / (1 + math.Exp(-1)),
+		},
+	}
+	actual := nbestFields("abc de", 3, splitPart)
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %d segmentations but got %d", len(expected), len(actual))
+	}
+	for i, seg := range actual {
+		exp := expected[i]
+		if !reflect.DeepEqual(seg.Fields, exp.Fields) || seg.LogProb != exp.LogProb ||
+			math.Abs(seg.Confidence-exp.Confidence) > 1e-8 {
+			t.Errorf("rank %d: expected %+v but got %+v", i, exp, seg)
+		}
+	}
+}
@@ -0,0 +1,361 @@
+package spacesplice
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"sort"
+	"strings"
+)
+
+const (
+	ngramDefaultOrder = 3
+	ngramBeamSize     = 64
+)
+
+// NGram is a Fielder that uses an n-gram language model
+// of any order to insert spaces into a piece of text.
+//
+// Probabilities are smoothed with interpolated
+// Kneser-Ney, backing off to successively shorter
+// contexts and finally to a model of unseen words.
+type NGram struct {
+	// FoldCase is true if the model's statistics are
+	// case-insensitive.
+	FoldCase bool
+
+	// Order is the number of words in each n-gram, so
+	// each word is conditioned on the Order-1 words
+	// before it.
+	Order int
+
+	// Counts maps contexts to the number of times each
+	// word followed them in the training corpus.
+	// A context consists of the Order-1 previous words
+	// joined by spaces, where "" takes the place of words
+	// before the start of a document.
+	Counts map[string]map[string]int
+
+	// Discounts stores the Kneser-Ney discount for each
+	// context length, starting with the unigram level.
+	Discounts []float64
+
+	// Unknown scores words which were never seen during
+	// training.
+	// It is not serialized, since it is rebuilt from the
+	// vocabulary when the model is deserialized.
+	Unknown *UnknownWords `json:"-"`
+
+	// levels stores the counts for each context length.
+	// All but the last level store continuation counts.
+	levels []*ngramLevel
+
+	// trie stores the vocabulary for enumerating known
+	// words longer than maxWordLen.
+	trie *trie
+}
+
+// TrainNGram trains an NGram model of the given order
+// on a directory full of sample text files.
+// If ignoreCase is true, the model ignores case.
+func TrainNGram(corpusDir string, order int, ignoreCase bool) (*NGram, error) {
+	if order < 1 {
+		return nil, errors.New("n-gram order must be at least 1")
+	}
+	res := &NGram{
+		FoldCase: ignoreCase,
+		Order:    order,
+		Counts:   map[string]map[string]int{},
+	}
+	if err := res.Update(corpusDir); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// DeserializeNGram deserializes an NGram model which
+// was serialized with NGram.Serialize().
+func DeserializeNGram(d []byte) (*NGram, error) {
+	var res NGram
+	if err := json.Unmarshal(d, &res); err != nil {
+		return nil, err
+	}
+	if res.Order < 1 {
+		return nil, errors.New("invalid n-gram order")
+	}
+	res.computeLevels()
+	res.Unknown = NewUnknownWords(res.vocab())
+	if len(res.Discounts) != res.Order {
+		res.estimateDiscounts()
+	}
+	return &res, nil
+}
+
+// Update trains the model further on a directory full of
+// sample text files, adding to its existing counts.
+func (n *NGram) Update(corpusDir string) error {
+	err := ReadSamples(corpusDir, func(sampleBody []byte) {
+		fields := Tokenize(string(sampleBody))
+		n.trainSample(fields)
+	})
+	if err != nil {
+		return err
+	}
+	n.computeLevels()
+	n.estimateDiscounts()
+	n.Unknown = NewUnknownWords(n.vocab())
+	return nil
+}
+
+// CondProb returns the smoothed probability of a word
+// given the words before it.
+// Only the last Order-1 previous words are used.
+// If n.FoldCase is set, the words are converted to lower
+// case first.
+// Missing previous words are treated as the start of a
+// document.
+func (n *NGram) CondProb(previous []string, word string) float64 {
+	context := make([]string, n.Order-1)
+	for i := range context {
+		idx := len(previous) - len(context) + i
+		if idx >= 0 {
+			context[i] = previous[idx]
+			if n.FoldCase {
+				context[i] = foldCase(context[i])
+			}
+		}
+	}
+	if n.FoldCase {
+		word = foldCase(word)
+	}
+	return n.condProb(context, word)
+}
+
+// Fields uses the n-gram model to split the spaceless
+// text into fields (i.e. words).
+//
+// Each whitespace-separated part of the text is split
+// with a beam search over word sequences.
+func (n *NGram) Fields(text string) []string {
+	var res []string
+	for _, part := range strings.Fields(text) {
+		if n.FoldCase {
+			res = append(res, splitLike(part, n.beamSearch(foldCase(part)))...)
+		} else {
+			res = append(res, n.beamSearch(part)...)
+		}
+	}
+	return joinPunctuation(res)
+}
+
+// SerializerType returns the unique ID used to
+// serialize the NGram type with the serializer
+// package.
+func (n *NGram) SerializerType() string {
+	return serializerTypeNGram
+}
+
+// Serialize serializes the NGram model.
+func (n *NGram) Serialize() ([]byte, error) {
+	return json.Marshal(n)
+}
+
+func (n *NGram) trainSample(fields []string) {
+	context := make([]string, n.Order-1)
+	for _, field := range fields {
+		if !markovAllowedField(field) {
+			continue
+		}
+		if n.FoldCase {
+			field = foldCase(field)
+		}
+		key := strings.Join(context, " ")
+		subTable := n.Counts[key]
+		if subTable == nil {
+			subTable = map[string]int{}
+			n.Counts[key] = subTable
+		}
+		subTable[field]++
+		if len(context) > 0 {
+			copy(context, context[1:])
+			context[len(context)-1] = field
+		}
+	}
+}
+
+// computeLevels derives the lower-order continuation
+// counts from the n-gram counts.
+func (n *NGram) computeLevels() {
+	n.levels = make([]*ngramLevel, n.Order)
+	n.levels[n.Order-1] = newNGramLevel()
+	for context, subTable := range n.Counts {
+		for word, count := range subTable {
+			n.levels[n.Order-1].add(context, word, count)
+		}
+	}
+	for i := n.Order - 2; i >= 0; i-- {
+		n.levels[i] = newNGramLevel()
+		for context, subTable := range n.levels[i+1].counts {
+			shorter := ngramShortenContext(context, i+1)
+			for word := range subTable {
+				n.levels[i].add(shorter, word, 1)
+			}
+		}
+	}
+	n.trie = newTrie(n.vocab())
+}
+
+func (n *NGram) estimateDiscounts() {
+	n.Discounts = make([]float64, n.Order)
+	for i, level := range n.levels {
+		var countCounts [3]int
+		for _, subTable := range level.counts {
+			for _, count := range subTable {
+				if count < len(countCounts) {
+					countCounts[count]++
+				}
+			}
+		}
+		n.Discounts[i] = markovDiscount(countCounts[1], countCounts[2])
+	}
+}
+
+// vocab returns every word seen during training.
+func (n *NGram) vocab() []string {
+	res := make([]string, 0, len(n.levels[0].counts[""]))
+	for word := range n.levels[0].counts[""] {
+		res = append(res, word)
+	}
+	return res
+}
+
+// condProb computes the interpolated probability of a
+// word given the last len(context) words.
+func (n *NGram) condProb(context []string, word string) float64 {
+	var lowerOrder float64
+	if n.Unknown != nil {
+		lowerOrder = n.Unknown.Prob(word)
+	} else {
+		lowerOrder = markovUnknownProb(word)
+	}
+	for i := 0; i <= len(context); i++ {
+		level := n.levels[i]
+		key := strings.Join(context[len(context)-i:], " ")
+		total := level.totals[key]
+		if total == 0 {
+			continue
+		}
+		subTable := level.counts[key]
+		discount := n.Discounts[i]
+		discounted := math.Max(float64(subTable[word])-discount, 0)
+		backoffMass := discount * float64(len(subTable))
+		lowerOrder = (discounted + backoffMass*lowerOrder) / float64(total)
+	}
+	return lowerOrder
+}
+
+// beamSearch finds a likely segmentation of str,
+// keeping only the best states at each position.
+func (n *NGram) beamSearch(str string) []string {
+	lattice := make([]map[string]*ngramState, len(str)+1)
+	lattice[0] = map[string]*ngramState{
+		"": {context: make([]string, n.Order-1)},
+	}
+	for end := 0; end < len(str); end++ {
+		for _, state := range pruneNGramStates(lattice[end]) {
+			followingCandidates(n.trie, str[end:], func(word string) {
+				newState := &ngramState{
+					word:    word,
+					prev:    state,
+					logProb: state.logProb + math.Log(n.condProb(state.context, word)),
+				}
+				if len(state.context) > 0 {
+					newState.context = append(append([]string{}, state.context[1:]...), word)
+				}
+				next := end + len(word)
+				if lattice[next] == nil {
+					lattice[next] = map[string]*ngramState{}
+				}
+				key := strings.Join(newState.context, " ")
+				if old, ok := lattice[next][key]; !ok || newState.logProb > old.logProb {
+					lattice[next][key] = newState
+				}
+			})
+		}
+		lattice[end] = nil
+	}
+
+	best := pruneNGramStates(lattice[len(str)])[0]
+	var res []string
+	for state := best; state.prev != nil; state = state.prev {
+		res = append(res, state.word)
+	}
+	for i := 0; i < len(res)/2; i++ {
+		res[i], res[len(res)-i-1] = res[len(res)-i-1], res[i]
+	}
+	return res
+}
+
+type ngramLevel struct {
+	counts map[string]map[string]int
+	totals map[string]int
+}
+
+func newNGramLevel() *ngramLevel {
+	return &ngramLevel{
+		counts: map[string]map[string]int{},
+		totals: map[string]int{},
+	}
+}
+
+func (n *ngramLevel) add(context, word string, count int) {
+	subTable := n.counts[context]
+	if subTable == nil {
+		subTable = map[string]int{}
+		n.counts[context] = subTable
+	}
+	subTable[word] += count
+	n.totals[context] += count
+}
+
+// ngramState is a node in the lattice used for the beam
+// search.
+type ngramState struct {
+	word    string
+	context []string
+	prev    *ngramState
+	logProb float64
+}
+
+// pruneNGramStates returns the most likely states,
+// sorted from most to least likely.
+func pruneNGramStates(states map[string]*ngramState) []*ngramState {
+	keys := make([]string, 0, len(states))
+	for key := range states {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		p1, p2 := states[keys[i]].logProb, states[keys[j]].logProb
+		if p1 == p2 {
+			return keys[i] < keys[j]
+		}
+		return p1 > p2
+	})
+	if len(keys) > ngramBeamSize {
+		keys = keys[:ngramBeamSize]
+	}
+	res := make([]*ngramState, len(keys))
+	for i, key := range keys {
+		res[i] = states[key]
+	}
+	return res
+}
+
+// ngramShortenContext removes the first word from a
+// context of the given length.
+func ngramShortenContext(context string, length int) string {
+	if length == 1 {
+		return ""
+	}
+	return context[strings.Index(context, " ")+1:]
+}
@@ -0,0 +1,15 @@
+package spacesplice
+
+import "testing"
+
+func TestNGramFoldCase(t *testing.T) {
+	n, err := TrainNGram(testCorpusDir(t), 3, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual := n.CondProb([]string{"The", "Training"}, "Data")
+	expected := n.CondProb([]string{"the", "training"}, "data")
+	if actual != expected {
+		t.Errorf("expected %v but got %v", expected, actual)
+	}
+}
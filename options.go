@@ -0,0 +1,93 @@
+package spacesplice
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Options stores model-specific training options, such
+// as hyperparameters, keyed by name.
+type Options map[string]string
+
+// ParseOptions parses a list of options of the form
+// "key=value".
+func ParseOptions(list []string) (Options, error) {
+	res := Options{}
+	for _, item := range list {
+		idx := strings.Index(item, "=")
+		if idx < 0 {
+			return nil, fmt.Errorf("invalid option (expected key=value): %s", item)
+		}
+		res[item[:idx]] = item[idx+1:]
+	}
+	return res, nil
+}
+
+// Check returns an error if any of the options is not
+// one of the given keys.
+func (o Options) Check(keys ...string) error {
+	for key := range o {
+		var found bool
+		for _, k := range keys {
+			if k == key {
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown option: %s", key)
+		}
+	}
+	return nil
+}
+
+// String returns the value for a key, or def if the
+// key is not set.
+func (o Options) String(key, def string) string {
+	if val, ok := o[key]; ok {
+		return val
+	}
+	return def
+}
+
+// Int returns the integer value for a key, or def if
+// the key is not set.
+func (o Options) Int(key string, def int) (int, error) {
+	val, ok := o[key]
+	if !ok {
+		return def, nil
+	}
+	res, err := strconv.Atoi(val)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value for %s: %s", key, val)
+	}
+	return res, nil
+}
+
+// Float returns the floating-point value for a key, or
+// def if the key is not set.
+func (o Options) Float(key string, def float64) (float64, error) {
+	val, ok := o[key]
+	if !ok {
+		return def, nil
+	}
+	res, err := strconv.ParseFloat(val, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value for %s: %s", key, val)
+	}
+	return res, nil
+}
+
+// Bool returns the boolean value for a key, or def if
+// the key is not set.
+func (o Options) Bool(key string, def bool) (bool, error) {
+	val, ok := o[key]
+	if !ok {
+		return def, nil
+	}
+	res, err := strconv.ParseBool(val)
+	if err != nil {
+		return false, fmt.Errorf("invalid value for %s: %s", key, val)
+	}
+	return res, nil
+}
@@ -2,15 +2,18 @@ package spacesplice
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"log"
 	"math/rand"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/unixpickle/autofunc"
 	"github.com/unixpickle/num-analysis/linalg"
+	"github.com/unixpickle/serializer"
 	"github.com/unixpickle/sgd"
-	"github.com/unixpickle/weakai/neuralnet"
 	"github.com/unixpickle/weakai/rnn"
 	"github.com/unixpickle/weakai/rnn/seqtoseq"
 )
@@ -18,88 +21,226 @@ import (
 const (
 	rnnSampleMinFields = 1
 	rnnSampleMaxFields = 20
-	rnnFeatureCount    = 256
-	rnnStateSize       = 128
-	rnnOutputHidden    = 128
 	rnnStepSize        = 0.001
 	rnnBatchSize       = 20
 	rnnMaxSamples      = 1 << 13
+	rnnInferenceBatch  = 64
 )
 
 // RNN splits a string into fields using a bidirectional
 // recurrent neural network.
 type RNN struct {
 	Net *rnn.Bidirectional
+
+	// Encoding converts text into the inputs of the
+	// network.
+	// If it is nil, the network takes one byte of text
+	// per timestep, like networks from before runes were
+	// supported.
+	Encoding *RNNEncoding
+
+	// Architecture describes the layers of the network.
+	// Networks from before the architecture was
+	// configurable use DefaultRNNArchitecture().
+	Architecture *RNNArchitecture
+
+	// adam is the state of the optimizer, which is saved
+	// so that training can be resumed.
+	// It is nil if the network has not been trained since
+	// it was loaded from a model without the state.
+	adam *rnnAdam
 }
 
+// DeserializeRNN deserializes an RNN which was
+// serialized with RNN.Serialize().
+//
+// Networks which were serialized without an encoding
+// or without the state of the optimizer are also
+// supported.
 func DeserializeRNN(d []byte) (*RNN, error) {
-	net, err := rnn.DeserializeBidirectional(d)
-	if err != nil {
-		return nil, err
+	var headerData, adamData []byte
+	var net *rnn.Bidirectional
+	if err := serializer.DeserializeAny(d, &headerData, &net, &adamData); err != nil {
+		if err := serializer.DeserializeAny(d, &net, &adamData); err != nil {
+			net, err := rnn.DeserializeBidirectional(d)
+			if err != nil {
+				return nil, err
+			}
+			return &RNN{Net: net, Architecture: DefaultRNNArchitecture()}, nil
+		}
 	}
-	return &RNN{Net: net}, nil
+	res := &RNN{Net: net, Architecture: DefaultRNNArchitecture()}
+	if len(headerData) > 0 {
+		var header rnnHeader
+		if err := json.Unmarshal(headerData, &header); err != nil {
+			return nil, err
+		}
+		if header.Encoding != nil {
+			if header.Encoding.HashBuckets < 0 || header.Encoding.inputSize() == 0 {
+				return nil, errors.New("invalid RNN encoding")
+			}
+			header.Encoding.computeIndices()
+		}
+		res.Encoding = header.Encoding
+		if header.Architecture != nil {
+			if err := header.Architecture.validate(); err != nil {
+				return nil, err
+			}
+			res.Architecture = header.Architecture
+		}
+	}
+	if len(adamData) > 0 {
+		var err error
+		res.adam, err = deserializeRNNAdam(adamData, net.Parameters())
+		if err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
 }
 
-func TrainRNN(corpusDir string) (*RNN, error) {
-	res := &RNN{Net: createRNN()}
+// TrainRNN trains an RNN on a directory full of
+// sample text files.
+// If config is nil, the default configuration is used.
+func TrainRNN(corpusDir string, config *RNNConfig) (*RNN, error) {
+	if config == nil {
+		config = &RNNConfig{}
+	}
+	maxRunes, hashBuckets := config.MaxRunes, config.HashBuckets
+	if maxRunes == 0 && hashBuckets == 0 {
+		maxRunes, hashBuckets = rnnDefaultMaxRunes, rnnDefaultHashBuckets
+	}
+	if maxRunes < 0 || hashBuckets < 0 || maxRunes+hashBuckets == 0 {
+		return nil, errors.New("invalid rune encoding size")
+	}
+	arch := config.Architecture
+	if arch == nil {
+		arch = DefaultRNNArchitecture()
+	}
+	if err := arch.validate(); err != nil {
+		return nil, err
+	}
 
-	log.Println("Loading samples...")
 	samples, err := createRNNSamples(corpusDir)
 	if err != nil {
 		return nil, err
 	}
+	res := &RNN{
+		Encoding:     newRNNEncoding(samples.texts, maxRunes, hashBuckets),
+		Architecture: arch,
+	}
+	res.Net = arch.build(res.Encoding.inputSize())
+	if err := res.train(samples, config); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
 
-	rand.Seed(time.Now().UnixNano())
-	sgd.ShuffleSampleSet(samples)
-	if samples.Len() > rnnMaxSamples {
-		samples = samples.Subset(0, rnnMaxSamples)
-	}
-
-	log.Printf("Training on %d samples (Ctrl+C to end)...", samples.Len())
-	cost := neuralnet.SigmoidCECost{}
-	grad := &sgd.Adam{
-		Gradienter: &seqtoseq.SeqFuncGradienter{
-			Learner:  res.Net,
-			SeqFunc:  res.Net,
-			CostFunc: cost,
-		},
-	}
-
-	var epoch int
-	sgd.SGDInteractive(grad, samples, rnnStepSize, rnnBatchSize, func() bool {
-		tc := seqtoseq.TotalCostSeqFunc(res.Net, 20, samples, cost)
-		log.Printf("Epoch %d: cost=%f", epoch, tc)
-		epoch++
-		return true
-	})
+// Train continues training the network on a directory
+// full of sample text files, restoring the state of the
+// optimizer if it was saved with the model.
+// This can resume an interrupted training run, or
+// fine-tune the network on text from a new domain.
+// If config is nil, the default configuration is used.
+func (r *RNN) Train(corpusDir string, config *RNNConfig) error {
+	samples, err := createRNNSamples(corpusDir)
+	if err != nil {
+		return err
+	}
+	return r.train(samples, config)
+}
 
-	return res, nil
+func (r *RNN) train(samples *rnnSampleSet, config *RNNConfig) error {
+	if config == nil {
+		config = &RNNConfig{}
+	}
+	samples.encoding = r.Encoding
+	trainer := &rnnTrainer{net: r, config: config}
+	return trainer.train(samples)
+}
+
+// Update continues training the network with the default
+// configuration.
+func (r *RNN) Update(corpusDir string) error {
+	return r.Train(corpusDir, nil)
+}
+
+// UpdateWithOptions continues training the network with
+// the options of the "rnn" trainer which control how it
+// is trained.
+// Options which configure the network itself, such as
+// its architecture and encoding, are rejected.
+func (r *RNN) UpdateWithOptions(corpusDir string, opts Options) error {
+	config, err := parseRNNUpdateOptions(opts)
+	if err != nil {
+		return err
+	}
+	return r.Train(corpusDir, config)
 }
 
 func (r *RNN) Fields(text string) []string {
-	var res []string
-	parts := strings.Fields(text)
-	for _, part := range parts {
-		partBytes := []byte(part)
-		inSeq := make([]autofunc.Result, len(partBytes))
-		for i, b := range partBytes {
-			v := make(linalg.Vector, rnnFeatureCount)
-			v[int(b)] = 1
-			inSeq[i] = &autofunc.Variable{Vector: v}
+	return r.BatchFields([]string{text})[0]
+}
+
+// BatchFields splits many pieces of text at once.
+// The whitespace-separated parts of all the texts are
+// run through the network together, in batches of parts
+// with similar lengths.
+//
+// Fields only end between runes, even for networks which
+// take one byte per timestep.
+func (r *RNN) BatchFields(texts []string) [][]string {
+	var parts []string
+	var owners []int
+	for i, text := range texts {
+		for _, part := range strings.Fields(text) {
+			parts = append(parts, part)
+			owners = append(owners, i)
+		}
+	}
+	order := make([]int, len(parts))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return len(parts[order[i]]) < len(parts[order[j]])
+	})
+
+	outs := make([][]linalg.Vector, len(parts))
+	ends := make([][]int, len(parts))
+	for i := 0; i < len(order); i += rnnInferenceBatch {
+		batch := order[i:minInt(i+rnnInferenceBatch, len(order))]
+		inSeqs := make([][]autofunc.Result, len(batch))
+		for j, idx := range batch {
+			var inputs []linalg.Vector
+			inputs, ends[idx] = r.Encoding.encode(parts[idx])
+			inSeqs[j] = make([]autofunc.Result, len(inputs))
+			for k, input := range inputs {
+				inSeqs[j][k] = &autofunc.Variable{Vector: input}
+			}
+		}
+		for j, out := range r.Net.BatchSeqs(inSeqs).OutputSeqs() {
+			outs[batch[j]] = out
 		}
-		out := r.Net.BatchSeqs([][]autofunc.Result{inSeq}).OutputSeqs()[0]
-
-		var buf bytes.Buffer
-		for i, b := range partBytes {
-			buf.WriteByte(b)
-			if out[i][0] > 0 {
-				res = append(res, buf.String())
-				buf.Reset()
+	}
+
+	res := make([][]string, len(texts))
+	for i, part := range parts {
+		fields := res[owners[i]]
+		var start int
+		for j, end := range ends[i] {
+			if outs[i][j][0] > 0 && canSplit(part, end) {
+				fields = append(fields, part[start:end])
+				start = end
 			}
 		}
-		if buf.Len() > 0 {
-			res = append(res, buf.String())
+		if start < len(part) {
+			fields = append(fields, part[start:])
 		}
+		res[owners[i]] = fields
+	}
+	for i, fields := range res {
+		res[i] = joinPunctuation(fields)
 	}
 	return res
 }
@@ -109,33 +250,37 @@ func (r *RNN) SerializerType() string {
 }
 
 func (r *RNN) Serialize() ([]byte, error) {
-	return r.Net.Serialize()
-}
-
-func createRNN() *rnn.Bidirectional {
-	outNet := neuralnet.Network{
-		&neuralnet.DenseLayer{
-			InputCount:  rnnStateSize * 2,
-			OutputCount: rnnOutputHidden,
-		},
-		&neuralnet.HyperbolicTangent{},
-		&neuralnet.DenseLayer{
-			InputCount:  rnnOutputHidden,
-			OutputCount: 1,
-		},
+	headerData, err := json.Marshal(&rnnHeader{
+		Encoding:     r.Encoding,
+		Architecture: r.Architecture,
+	})
+	if err != nil {
+		return nil, err
 	}
-	outNet.Randomize()
-	return &rnn.Bidirectional{
-		Forward:  &rnn.BlockSeqFunc{Block: rnn.NewGRU(rnnFeatureCount, rnnStateSize)},
-		Backward: &rnn.BlockSeqFunc{Block: rnn.NewGRU(rnnFeatureCount, rnnStateSize)},
-		Output:   &rnn.NetworkSeqFunc{Network: outNet},
+	var adamData []byte
+	if r.adam != nil {
+		var err error
+		adamData, err = r.adam.serialize()
+		if err != nil {
+			return nil, err
+		}
 	}
+	return serializer.SerializeAny(headerData, r.Net, adamData)
+}
+
+// rnnHeader stores the settings of an RNN besides the
+// network itself.
+type rnnHeader struct {
+	Encoding     *RNNEncoding
+	Architecture *RNNArchitecture
 }
 
-func createRNNSamples(corpusDir string) (sgd.SampleSet, error) {
+func createRNNSamples(corpusDir string) (*rnnSampleSet, error) {
+	log.Println("Loading samples...")
+	rand.Seed(time.Now().UnixNano())
 	var res rnnSampleSet
 	err := ReadSamples(corpusDir, func(sampleBody []byte) {
-		fields := strings.Fields(string(sampleBody))
+		fields := Tokenize(string(sampleBody))
 		for len(fields) > 0 {
 			fieldCount := rand.Intn(1+rnnSampleMaxFields-rnnSampleMinFields) +
 				rnnSampleMinFields
@@ -145,7 +290,7 @@ func createRNNSamples(corpusDir string) (sgd.SampleSet, error) {
 			subFields := fields[:fieldCount]
 			fields = fields[fieldCount:]
 			data, bounds := rnnBoundedSample(subFields)
-			res.samples = append(res.samples, data)
+			res.texts = append(res.texts, data)
 			res.endFlags = append(res.endFlags, bounds)
 		}
 	})
@@ -155,41 +300,42 @@ func createRNNSamples(corpusDir string) (sgd.SampleSet, error) {
 	return &res, nil
 }
 
+// rnnSampleSet stores pieces of text along with a flag
+// for each byte which is true if a field ends there.
 type rnnSampleSet struct {
-	samples  [][]byte
+	texts    []string
 	endFlags [][]bool
+	encoding *RNNEncoding
 }
 
 func (r *rnnSampleSet) Len() int {
-	return len(r.samples)
+	return len(r.texts)
 }
 
 func (r *rnnSampleSet) Copy() sgd.SampleSet {
 	res := &rnnSampleSet{
-		samples:  make([][]byte, len(r.samples)),
+		texts:    make([]string, len(r.texts)),
 		endFlags: make([][]bool, len(r.endFlags)),
+		encoding: r.encoding,
 	}
-	copy(res.samples, r.samples)
+	copy(res.texts, r.texts)
 	copy(res.endFlags, r.endFlags)
 	return res
 }
 
 func (r *rnnSampleSet) Swap(i, j int) {
-	r.samples[i], r.samples[j] = r.samples[j], r.samples[i]
+	r.texts[i], r.texts[j] = r.texts[j], r.texts[i]
 	r.endFlags[i], r.endFlags[j] = r.endFlags[j], r.endFlags[i]
 }
 
 func (r *rnnSampleSet) GetSample(idx int) interface{} {
-	data := r.samples[idx]
+	inputs, ends := r.encoding.encode(r.texts[idx])
 	res := seqtoseq.Sample{
-		Inputs:  make([]linalg.Vector, len(data)),
-		Outputs: make([]linalg.Vector, len(data)),
-	}
-	for i, b := range data {
-		inVec := make(linalg.Vector, rnnFeatureCount)
-		inVec[int(b)] = 1
-		res.Inputs[i] = inVec
-		if r.endFlags[idx][i] {
+		Inputs:  inputs,
+		Outputs: make([]linalg.Vector, len(inputs)),
+	}
+	for i, end := range ends {
+		if r.endFlags[idx][end-1] {
 			res.Outputs[i] = []float64{1}
 		} else {
 			res.Outputs[i] = []float64{0}
@@ -200,8 +346,9 @@ func (r *rnnSampleSet) GetSample(idx int) interface{} {
 
 func (r *rnnSampleSet) Subset(start, end int) sgd.SampleSet {
 	return &rnnSampleSet{
-		samples:  r.samples[start:end],
+		texts:    r.texts[start:end],
 		endFlags: r.endFlags[start:end],
+		encoding: r.encoding,
 	}
 }
 
@@ -214,13 +361,13 @@ func (r *rnnBatchLearner) Parameters() []*autofunc.Variable {
 	return r.Params
 }
 
-func rnnBoundedSample(fields []string) (sample []byte, bounds []bool) {
+func rnnBoundedSample(fields []string) (sample string, bounds []bool) {
+	var buf bytes.Buffer
 	for _, field := range fields {
-		byteField := []byte(field)
-		for i, x := range byteField {
-			sample = append(sample, x)
-			bounds = append(bounds, i == len(byteField)-1)
+		for i := 0; i < len(field); i++ {
+			buf.WriteByte(field[i])
+			bounds = append(bounds, i == len(field)-1)
 		}
 	}
-	return
+	return buf.String(), bounds
 }
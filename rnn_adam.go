@@ -0,0 +1,99 @@
+package spacesplice
+
+import (
+	"errors"
+	"math"
+
+	"github.com/unixpickle/autofunc"
+	"github.com/unixpickle/num-analysis/linalg"
+	"github.com/unixpickle/serializer"
+	"github.com/unixpickle/sgd"
+)
+
+const (
+	rnnAdamDecayRate1 = 0.9
+	rnnAdamDecayRate2 = 0.999
+	rnnAdamDamping    = 1e-8
+)
+
+// rnnAdam implements the same algorithm as sgd.Adam, but
+// its moments can be saved with a model so that training
+// can resume where it left off.
+type rnnAdam struct {
+	Gradienter sgd.Gradienter
+
+	params       []*autofunc.Variable
+	firstMoment  []linalg.Vector
+	secondMoment []linalg.Vector
+	iteration    float64
+}
+
+// newRNNAdam creates an rnnAdam with no history for the
+// given parameters.
+func newRNNAdam(params []*autofunc.Variable) *rnnAdam {
+	res := &rnnAdam{params: params}
+	for _, param := range params {
+		res.firstMoment = append(res.firstMoment, make(linalg.Vector, len(param.Vector)))
+		res.secondMoment = append(res.secondMoment, make(linalg.Vector, len(param.Vector)))
+	}
+	return res
+}
+
+// deserializeRNNAdam decodes the moments saved with
+// rnnAdam.serialize(), checking that they fit params.
+func deserializeRNNAdam(d []byte, params []*autofunc.Variable) (*rnnAdam, error) {
+	var iteration float64
+	var first, second []float64
+	if err := serializer.DeserializeAny(d, &iteration, &first, &second); err != nil {
+		return nil, err
+	}
+	res := &rnnAdam{params: params, iteration: iteration}
+	var offset int
+	for _, param := range params {
+		end := offset + len(param.Vector)
+		if end > len(first) || end > len(second) {
+			return nil, errors.New("Adam state does not match network")
+		}
+		res.firstMoment = append(res.firstMoment, first[offset:end])
+		res.secondMoment = append(res.secondMoment, second[offset:end])
+		offset = end
+	}
+	if offset != len(first) || offset != len(second) {
+		return nil, errors.New("Adam state does not match network")
+	}
+	return res, nil
+}
+
+// Gradient computes the gradient with the wrapped
+// Gradienter and scales it using the moments.
+func (a *rnnAdam) Gradient(s sgd.SampleSet) autofunc.Gradient {
+	grad := a.Gradienter.Gradient(s)
+
+	a.iteration++
+	scale := math.Sqrt(1-math.Pow(rnnAdamDecayRate2, a.iteration)) /
+		(1 - math.Pow(rnnAdamDecayRate1, a.iteration))
+	for i, param := range a.params {
+		vec, ok := grad[param]
+		if !ok {
+			continue
+		}
+		first, second := a.firstMoment[i], a.secondMoment[i]
+		for j, x := range vec {
+			first[j] = rnnAdamDecayRate1*first[j] + (1-rnnAdamDecayRate1)*x
+			second[j] = rnnAdamDecayRate2*second[j] + (1-rnnAdamDecayRate2)*x*x
+			vec[j] = scale * first[j] / math.Sqrt(second[j]+rnnAdamDamping)
+		}
+	}
+	return grad
+}
+
+// serialize encodes the moments, which are stored in
+// the same order as the parameters.
+func (a *rnnAdam) serialize() ([]byte, error) {
+	var first, second []float64
+	for i := range a.params {
+		first = append(first, a.firstMoment[i]...)
+		second = append(second, a.secondMoment[i]...)
+	}
+	return serializer.SerializeAny(a.iteration, first, second)
+}
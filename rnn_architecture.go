@@ -0,0 +1,170 @@
+package spacesplice
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/unixpickle/weakai/neuralnet"
+	"github.com/unixpickle/weakai/rnn"
+)
+
+const (
+	rnnDefaultStateSize    = 128
+	rnnDefaultOutputHidden = 128
+)
+
+// Cell types for RNNArchitecture.
+const (
+	RNNCellGRU  = "gru"
+	RNNCellLSTM = "lstm"
+)
+
+// RNNArchitecture describes the layers of an RNN.
+type RNNArchitecture struct {
+	// Cell is the type of recurrent cell, either
+	// RNNCellGRU or RNNCellLSTM.
+	Cell string
+
+	// Layers is the number of stacked recurrent layers
+	// in each direction.
+	Layers int
+
+	// StateSize is the number of hidden units in each
+	// recurrent layer.
+	StateSize int
+
+	// OutputHidden lists the sizes of the hidden layers
+	// of the network which turns the states of both
+	// directions into a boundary prediction.
+	OutputHidden []int
+
+	// Dropout is the probability that each input to a
+	// layer of the output network is dropped during
+	// training.
+	Dropout float64
+}
+
+// DefaultRNNArchitecture returns the architecture used
+// by networks from before the architecture was
+// configurable: one GRU layer in each direction and one
+// hidden layer in the output network.
+func DefaultRNNArchitecture() *RNNArchitecture {
+	return &RNNArchitecture{
+		Cell:         RNNCellGRU,
+		Layers:       1,
+		StateSize:    rnnDefaultStateSize,
+		OutputHidden: []int{rnnDefaultOutputHidden},
+	}
+}
+
+// ParseRNNHidden parses a comma-separated list of hidden
+// layer sizes for RNNArchitecture.OutputHidden.
+// An empty list means that there are no hidden layers.
+func ParseRNNHidden(list string) ([]int, error) {
+	var res []int
+	for _, field := range strings.Split(list, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		size, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, errors.New("invalid hidden layer size: " + field)
+		}
+		res = append(res, size)
+	}
+	return res, nil
+}
+
+// String returns a short human-readable description of
+// the architecture.
+func (a *RNNArchitecture) String() string {
+	hidden := make([]string, len(a.OutputHidden))
+	for i, size := range a.OutputHidden {
+		hidden[i] = strconv.Itoa(size)
+	}
+	return fmt.Sprintf("%dx%s(%d) output=[%s] dropout=%g", a.Layers, a.Cell, a.StateSize,
+		strings.Join(hidden, ","), a.Dropout)
+}
+
+func (a *RNNArchitecture) validate() error {
+	if a.Cell != RNNCellGRU && a.Cell != RNNCellLSTM {
+		return errors.New("unknown RNN cell: " + a.Cell)
+	}
+	if a.Layers < 1 || a.StateSize < 1 {
+		return errors.New("RNN must have at least one layer and state unit")
+	}
+	for _, size := range a.OutputHidden {
+		if size < 1 {
+			return errors.New("RNN hidden layers must not be empty")
+		}
+	}
+	if a.Dropout < 0 || a.Dropout >= 1 {
+		return errors.New("dropout must be in [0, 1)")
+	}
+	return nil
+}
+
+// build creates a randomly initialized network with the
+// architecture.
+func (a *RNNArchitecture) build(inputSize int) *rnn.Bidirectional {
+	var outNet neuralnet.Network
+	lastSize := a.StateSize * 2
+	addDense := func(size int) {
+		if a.Dropout > 0 {
+			outNet = append(outNet, &neuralnet.DropoutLayer{KeepProbability: 1 - a.Dropout})
+		}
+		outNet = append(outNet, &neuralnet.DenseLayer{
+			InputCount:  lastSize,
+			OutputCount: size,
+		})
+		lastSize = size
+	}
+	for _, size := range a.OutputHidden {
+		addDense(size)
+		outNet = append(outNet, &neuralnet.HyperbolicTangent{})
+	}
+	addDense(1)
+	outNet.Randomize()
+	return &rnn.Bidirectional{
+		Forward:  &rnn.BlockSeqFunc{Block: a.buildBlock(inputSize)},
+		Backward: &rnn.BlockSeqFunc{Block: a.buildBlock(inputSize)},
+		Output:   &rnn.NetworkSeqFunc{Network: outNet},
+	}
+}
+
+func (a *RNNArchitecture) buildBlock(inputSize int) rnn.Block {
+	var layers rnn.StackedBlock
+	for i := 0; i < a.Layers; i++ {
+		layerInput := a.StateSize
+		if i == 0 {
+			layerInput = inputSize
+		}
+		if a.Cell == RNNCellLSTM {
+			layers = append(layers, rnn.NewLSTM(layerInput, a.StateSize))
+		} else {
+			layers = append(layers, rnn.NewGRU(layerInput, a.StateSize))
+		}
+	}
+	if len(layers) == 1 {
+		return layers[0]
+	}
+	return layers
+}
+
+// setDropoutTraining enables or disables the random
+// dropout in the output network of a Bidirectional.
+// Dropout should only be enabled while training.
+func setDropoutTraining(net *rnn.Bidirectional, training bool) {
+	output, ok := net.Output.(*rnn.NetworkSeqFunc)
+	if !ok {
+		return
+	}
+	for _, layer := range output.Network {
+		if dropout, ok := layer.(*neuralnet.DropoutLayer); ok {
+			dropout.Training = training
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package spacesplice
+
+import (
+	"sort"
+	"unicode/utf8"
+
+	"github.com/unixpickle/num-analysis/linalg"
+)
+
+const (
+	rnnDefaultMaxRunes    = 256
+	rnnDefaultHashBuckets = 64
+
+	// rnnMinRuneCount is the number of times a rune must
+	// appear in the corpus to get its own input.
+	rnnMinRuneCount = 2
+
+	// rnnByteFeatureCount is the input size of networks
+	// from before runes were supported, which take one
+	// byte per timestep.
+	rnnByteFeatureCount = 256
+)
+
+// RNNEncoding converts text into the inputs of an RNN,
+// with one timestep per rune.
+//
+// Each of the most common runes in the training corpus
+// has its own input, and every other rune is hashed
+// into one of a few shared inputs.
+type RNNEncoding struct {
+	Runes       []rune
+	HashBuckets int
+
+	indices map[rune]int
+}
+
+// newRNNEncoding creates an encoding for the runes
+// which appear in a list of texts.
+func newRNNEncoding(texts []string, maxRunes, hashBuckets int) *RNNEncoding {
+	counts := map[rune]int{}
+	for _, text := range texts {
+		for _, r := range text {
+			counts[r]++
+		}
+	}
+	res := &RNNEncoding{HashBuckets: hashBuckets}
+	for r, count := range counts {
+		if count >= rnnMinRuneCount {
+			res.Runes = append(res.Runes, r)
+		}
+	}
+	sort.Slice(res.Runes, func(i, j int) bool {
+		c1, c2 := counts[res.Runes[i]], counts[res.Runes[j]]
+		if c1 == c2 {
+			return res.Runes[i] < res.Runes[j]
+		}
+		return c1 > c2
+	})
+	if len(res.Runes) > maxRunes {
+		res.Runes = res.Runes[:maxRunes]
+	}
+	res.computeIndices()
+	return res
+}
+
+func (e *RNNEncoding) computeIndices() {
+	e.indices = map[rune]int{}
+	for i, r := range e.Runes {
+		e.indices[r] = i
+	}
+}
+
+// inputSize returns the size of each input vector.
+//
+// A nil encoding one-hot encodes bytes instead of runes,
+// like networks from before runes were supported.
+func (e *RNNEncoding) inputSize() int {
+	if e == nil {
+		return rnnByteFeatureCount
+	}
+	return len(e.Runes) + e.HashBuckets
+}
+
+// encode converts text into input vectors.
+// It also returns the offset of the end of each
+// timestep's rune (or byte) in the text.
+func (e *RNNEncoding) encode(text string) (inputs []linalg.Vector, ends []int) {
+	if e == nil {
+		for i := 0; i < len(text); i++ {
+			vec := make(linalg.Vector, rnnByteFeatureCount)
+			vec[int(text[i])] = 1
+			inputs = append(inputs, vec)
+			ends = append(ends, i+1)
+		}
+		return
+	}
+	for i, r := range text {
+		vec := make(linalg.Vector, e.inputSize())
+		if idx := e.index(r); idx >= 0 {
+			vec[idx] = 1
+		}
+		inputs = append(inputs, vec)
+		_, size := utf8.DecodeRuneInString(text[i:])
+		ends = append(ends, i+size)
+	}
+	return
+}
+
+// index returns the input for a rune, or -1 if the rune
+// is unknown and there are no hash buckets.
+func (e *RNNEncoding) index(r rune) int {
+	if idx, ok := e.indices[r]; ok {
+		return idx
+	}
+	if e.HashBuckets == 0 {
+		return -1
+	}
+	hash := uint32(r) * 2654435761
+	return len(e.Runes) + int(hash%uint32(e.HashBuckets))
+}
+
+// canSplit checks if a field may end before the byte at
+// idx, which is only true between runes.
+func canSplit(text string, idx int) bool {
+	return idx >= len(text) || utf8.RuneStart(text[idx])
+}
@@ -0,0 +1,19 @@
+package spacesplice
+
+import "testing"
+
+func TestRNNUpdateOptions(t *testing.T) {
+	for _, key := range rnnNetworkOptions {
+		r := &RNN{Architecture: DefaultRNNArchitecture()}
+		if err := r.UpdateWithOptions("", Options{key: "1"}); err == nil {
+			t.Errorf("option %s was accepted for an update", key)
+		}
+	}
+	config, err := parseRNNUpdateOptions(Options{"maxepochs": "3", "stepsize": "0.01"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.MaxEpochs != 3 || config.StepSize != 0.01 || config.Architecture != nil {
+		t.Errorf("unexpected config: %+v", config)
+	}
+}
@@ -0,0 +1,258 @@
+package spacesplice
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/unixpickle/autofunc"
+	"github.com/unixpickle/num-analysis/linalg"
+	"github.com/unixpickle/serializer"
+	"github.com/unixpickle/sgd"
+	"github.com/unixpickle/weakai/neuralnet"
+	"github.com/unixpickle/weakai/rnn/seqtoseq"
+)
+
+const (
+	rnnDefaultValidation = 0.1
+	rnnDefaultPatience   = 5
+	rnnMaxValidation     = 1 << 10
+)
+
+// RNNConfig stores the settings used to train an RNN.
+// Zero values select the defaults.
+type RNNConfig struct {
+	// Validation is the fraction of the samples which is
+	// held out of training to measure the boundary F1
+	// score after every epoch.
+	Validation float64
+
+	// MaxEpochs is the number of epochs after which
+	// training stops.
+	// If it is 0, training continues until it stops
+	// early or is interrupted with Ctrl+C.
+	MaxEpochs int
+
+	// Patience is the number of epochs without an
+	// improvement in the validation F1 score after which
+	// training stops.
+	Patience int
+
+	// Checkpoint is the path of a file to which the model
+	// is saved during training.
+	// If it is empty, no checkpoints are saved.
+	Checkpoint string
+
+	// CheckpointInterval is the number of epochs between
+	// checkpoints.
+	CheckpointInterval int
+
+	// MaxRunes is the number of common runes which get
+	// their own input, and HashBuckets is the number of
+	// inputs shared by the other runes.
+	// If both are 0, the defaults are used.
+	// They are only used when a new network is created.
+	MaxRunes    int
+	HashBuckets int
+
+	// Architecture describes the layers of the network.
+	// If it is nil, DefaultRNNArchitecture() is used.
+	// It is only used when a new network is created.
+	Architecture *RNNArchitecture
+
+	// StepSize is the learning rate.
+	// A smaller step size may be useful to fine-tune an
+	// existing network on a new domain.
+	StepSize float64
+}
+
+// rnnTrainer runs the training loop for an RNN, keeping
+// track of the best model seen on the validation set.
+type rnnTrainer struct {
+	net    *RNN
+	config *RNNConfig
+
+	bestF1    float64
+	bestData  []byte
+	badEpochs int
+}
+
+// train trains the network on the samples until it stops
+// early, reaches the maximum number of epochs, or is
+// interrupted.
+// Afterwards, the network is reset to the weights which
+// performed best on the validation samples.
+func (r *rnnTrainer) train(samples sgd.SampleSet) error {
+	config := r.config
+	validationFrac := config.Validation
+	if validationFrac == 0 {
+		validationFrac = rnnDefaultValidation
+	}
+	if validationFrac < 0 || validationFrac >= 1 {
+		return errors.New("validation fraction must be in [0, 1)")
+	}
+	if config.MaxEpochs < 0 || config.Patience < 0 || config.CheckpointInterval < 0 {
+		return errors.New("epoch counts cannot be negative")
+	}
+	stepSize := config.StepSize
+	if stepSize == 0 {
+		stepSize = rnnStepSize
+	}
+	if stepSize < 0 {
+		return errors.New("step size cannot be negative")
+	}
+
+	sgd.ShuffleSampleSet(samples)
+	numValidation := int(float64(samples.Len())*validationFrac + 0.5)
+	if numValidation > rnnMaxValidation {
+		numValidation = rnnMaxValidation
+	}
+	validation := samples.Subset(0, numValidation)
+	samples = samples.Subset(numValidation, samples.Len())
+	if samples.Len() > rnnMaxSamples {
+		samples = samples.Subset(0, rnnMaxSamples)
+	}
+	if samples.Len() == 0 || validation.Len() == 0 {
+		return errors.New("not enough samples")
+	}
+
+	log.Printf("Training on %d samples with %d validation samples (Ctrl+C to end)...",
+		samples.Len(), validation.Len())
+	cost := neuralnet.SigmoidCECost{}
+	if r.net.adam == nil {
+		r.net.adam = newRNNAdam(r.net.Net.Parameters())
+	} else {
+		log.Printf("Resuming optimizer after %d steps", int(r.net.adam.iteration))
+	}
+	r.net.adam.Gradienter = &seqtoseq.SeqFuncGradienter{
+		Learner:  r.net.Net,
+		SeqFunc:  r.net.Net,
+		CostFunc: cost,
+	}
+
+	var epoch int
+	var err error
+	setDropoutTraining(r.net.Net, true)
+	sgd.SGDInteractive(r.net.adam, samples, stepSize, rnnBatchSize, func() bool {
+		// Dropout is disabled while the network is evaluated
+		// or saved between epochs.
+		setDropoutTraining(r.net.Net, false)
+		if epoch > 0 {
+			tc := seqtoseq.TotalCostSeqFunc(r.net.Net, rnnBatchSize, samples, cost)
+			f1 := r.net.boundaryF1(validation)
+			log.Printf("Epoch %d: cost=%f validation_f1=%f", epoch, tc, f1)
+			var stop bool
+			if stop, err = r.endEpoch(epoch, f1); stop || err != nil {
+				return false
+			}
+		}
+		if config.MaxEpochs != 0 && epoch >= config.MaxEpochs {
+			return false
+		}
+		epoch++
+		setDropoutTraining(r.net.Net, true)
+		return true
+	})
+	setDropoutTraining(r.net.Net, false)
+	if err != nil {
+		return err
+	}
+
+	if r.bestData != nil {
+		best, err := DeserializeRNN(r.bestData)
+		if err != nil {
+			return err
+		}
+		r.net.Net, r.net.adam = best.Net, best.adam
+		log.Printf("Using the model with validation F1 %f", r.bestF1)
+	}
+	return nil
+}
+
+// endEpoch records the validation F1 score after an epoch
+// and saves a checkpoint if one is due.
+// It returns true if training should stop early.
+func (r *rnnTrainer) endEpoch(epoch int, f1 float64) (stop bool, err error) {
+	if r.bestData == nil || f1 > r.bestF1 {
+		r.bestF1 = f1
+		r.badEpochs = 0
+		if r.bestData, err = r.net.Serialize(); err != nil {
+			return true, err
+		}
+	} else {
+		r.badEpochs++
+	}
+
+	interval := r.config.CheckpointInterval
+	if interval == 0 {
+		interval = 1
+	}
+	if r.config.Checkpoint != "" && epoch%interval == 0 {
+		if err := r.saveCheckpoint(); err != nil {
+			return true, err
+		}
+	}
+
+	patience := r.config.Patience
+	if patience == 0 {
+		patience = rnnDefaultPatience
+	}
+	if r.badEpochs >= patience {
+		log.Printf("Stopping early: no improvement in %d epochs", r.badEpochs)
+		return true, nil
+	}
+	return false, nil
+}
+
+// saveCheckpoint writes the current model to the
+// checkpoint file, replacing the old checkpoint only
+// once the new one has been written completely.
+func (r *rnnTrainer) saveCheckpoint() error {
+	data, err := serializer.SerializeWithType(r.net)
+	if err != nil {
+		return err
+	}
+	tempPath := r.config.Checkpoint + ".tmp"
+	if err := ioutil.WriteFile(tempPath, data, 0755); err != nil {
+		return err
+	}
+	return os.Rename(tempPath, r.config.Checkpoint)
+}
+
+// boundaryF1 computes the F1 score of the boundaries
+// which the network predicts for a set of samples.
+func (r *RNN) boundaryF1(samples sgd.SampleSet) float64 {
+	var truePos, falsePos, falseNeg int
+	for i := 0; i < samples.Len(); i += rnnBatchSize {
+		batch := samples.Subset(i, minInt(i+rnnBatchSize, samples.Len()))
+		var inSeqs [][]autofunc.Result
+		var outSeqs [][]linalg.Vector
+		for j := 0; j < batch.Len(); j++ {
+			sample := batch.GetSample(j).(seqtoseq.Sample)
+			inSeq := make([]autofunc.Result, len(sample.Inputs))
+			for k, in := range sample.Inputs {
+				inSeq[k] = &autofunc.Variable{Vector: in}
+			}
+			inSeqs = append(inSeqs, inSeq)
+			outSeqs = append(outSeqs, sample.Outputs)
+		}
+		for j, actual := range r.Net.BatchSeqs(inSeqs).OutputSeqs() {
+			for k, out := range actual {
+				predicted := out[0] > 0
+				expected := outSeqs[j][k][0] == 1
+				if predicted && expected {
+					truePos++
+				} else if predicted {
+					falsePos++
+				} else if expected {
+					falseNeg++
+				}
+			}
+		}
+	}
+	if truePos == 0 {
+		return 0
+	}
+	return 2 * float64(truePos) / float64(2*truePos+falsePos+falseNeg)
+}
@@ -5,6 +5,7 @@ import "github.com/unixpickle/serializer"
 const (
 	serializerPrefix          = "github.com/unixpickle/spacesplice."
 	serializerTypeMarkov      = serializerPrefix + "Markov"
+	serializerTypeNGram       = serializerPrefix + "NGram"
 	serializerTypeDictionary  = serializerPrefix + "Dictionary"
 	serializerTypeForest      = serializerPrefix + "Forest"
 	serializerTypeRNN         = serializerPrefix + "RNN"
@@ -13,6 +14,7 @@ const (
 
 func init() {
 	serializer.RegisterTypedDeserializer(serializerTypeMarkov, DeserializeMarkov)
+	serializer.RegisterTypedDeserializer(serializerTypeNGram, DeserializeNGram)
 	serializer.RegisterTypedDeserializer(serializerTypeDictionary, DeserializeDictionary)
 	serializer.RegisterTypedDeserializer(serializerTypeForest, DeserializeForest)
 	serializer.RegisterTypedDeserializer(serializerTypeRNN, DeserializeRNN)
@@ -7,7 +7,12 @@
 // thing reliably?
 package spacesplice
 
-import "github.com/unixpickle/serializer"
+import (
+	"errors"
+	"strings"
+
+	"github.com/unixpickle/serializer"
+)
 
 // A Fielder is anything capable of splitting a piece
 // of text into "fields", which are words which would
@@ -24,26 +29,309 @@ type Fielder interface {
 	Fields(text string) []string
 }
 
+// An Updater is a Fielder which can be trained further
+// on more text samples without starting from scratch.
+type Updater interface {
+	Fielder
+
+	// Update trains the model on a directory of text
+	// samples, adding to what it has already learned.
+	Update(corpusDir string) error
+}
+
+// A BatchFielder is a Fielder which can split many
+// pieces of text faster together than one at a time.
+type BatchFielder interface {
+	Fielder
+
+	// BatchFields returns the result of Fields for each
+	// piece of text.
+	BatchFields(texts []string) [][]string
+}
+
+// An OptionsUpdater is an Updater which accepts options
+// for further training, such as a learning rate.
+type OptionsUpdater interface {
+	Updater
+
+	// UpdateWithOptions is like Update, but it returns an
+	// error for options the model does not support.
+	UpdateWithOptions(corpusDir string, opts Options) error
+}
+
 // TrainFunc is any function which trains a Fielder on
 // a directory of text samples.
-type TrainFunc func(corpusDir string) (Fielder, error)
+// The options configure the model being trained, and
+// an error is returned for options the model does not
+// support.
+type TrainFunc func(corpusDir string, opts Options) (Fielder, error)
 
 // Trainers maps the names of various text prediction
 // models to TrainFuncs for those models.
+//
+// The "wordlist", "freqlist", and "hunspell" trainers
+// build a Dictionary from a word list file instead of a
+// corpus directory.
 var Trainers = map[string]TrainFunc{
-	"markov": func(corpusDir string) (Fielder, error) {
-		return TrainMarkov(corpusDir)
+	"markov": func(corpusDir string, opts Options) (Fielder, error) {
+		if err := opts.Check("foldcase"); err != nil {
+			return nil, err
+		}
+		ignoreCase, err := opts.Bool("foldcase", true)
+		if err != nil {
+			return nil, err
+		}
+		return TrainMarkov(corpusDir, ignoreCase)
+	},
+	"ngram": func(corpusDir string, opts Options) (Fielder, error) {
+		if err := opts.Check("order", "foldcase"); err != nil {
+			return nil, err
+		}
+		order, err := opts.Int("order", ngramDefaultOrder)
+		if err != nil {
+			return nil, err
+		}
+		ignoreCase, err := opts.Bool("foldcase", true)
+		if err != nil {
+			return nil, err
+		}
+		return TrainNGram(corpusDir, order, ignoreCase)
+	},
+	"dict": func(corpusDir string, opts Options) (Fielder, error) {
+		d, err := parseDictionaryOptions(opts)
+		if err != nil {
+			return nil, err
+		}
+		return d.apply(TrainDictionary(corpusDir, d.Mode, d.IgnoreCase))
+	},
+	"wordlist": func(path string, opts Options) (Fielder, error) {
+		d, err := parseDictionaryOptions(opts)
+		if err != nil {
+			return nil, err
+		}
+		return d.apply(LoadWordList(path, d.Mode, d.IgnoreCase))
+	},
+	"freqlist": func(path string, opts Options) (Fielder, error) {
+		d, err := parseDictionaryOptions(opts)
+		if err != nil {
+			return nil, err
+		}
+		return d.apply(LoadFrequencyList(path, d.Mode, d.IgnoreCase))
 	},
-	"dict": func(corpusDir string) (Fielder, error) {
-		return TrainDictionary(corpusDir)
+	"hunspell": func(dicPath string, opts Options) (Fielder, error) {
+		d, err := parseDictionaryOptions(opts, "aff")
+		if err != nil {
+			return nil, err
+		}
+		affPath := opts.String("aff", strings.TrimSuffix(dicPath, ".dic")+".aff")
+		return d.apply(LoadHunspell(dicPath, affPath, d.Mode, d.IgnoreCase))
 	},
-	"forest": func(corpusDir string) (Fielder, error) {
-		return TrainForest(corpusDir)
+	"forest": func(corpusDir string, opts Options) (Fielder, error) {
+		config, err := parseForestOptions(opts)
+		if err != nil {
+			return nil, err
+		}
+		return TrainForest(corpusDir, config)
 	},
-	"rnn": func(corpusDir string) (Fielder, error) {
-		return TrainRNN(corpusDir)
+	"rnn": func(corpusDir string, opts Options) (Fielder, error) {
+		config, err := parseRNNOptions(opts)
+		if err != nil {
+			return nil, err
+		}
+		return TrainRNN(corpusDir, config)
 	},
-	"booststumps": func(corpusDir string) (Fielder, error) {
+	"booststumps": func(corpusDir string, opts Options) (Fielder, error) {
+		if err := opts.Check(); err != nil {
+			return nil, err
+		}
 		return TrainBoostStumps(corpusDir)
 	},
 }
+
+// dictionaryOptions stores the options shared by the
+// trainers which produce a Dictionary.
+type dictionaryOptions struct {
+	Mode       DictionaryMode
+	IgnoreCase bool
+	MaxEdits   int
+	EditCost   float64
+}
+
+func parseDictionaryOptions(opts Options, extraKeys ...string) (*dictionaryOptions, error) {
+	keys := append([]string{"mode", "foldcase", "maxedits", "editcost"}, extraKeys...)
+	if err := opts.Check(keys...); err != nil {
+		return nil, err
+	}
+	res := &dictionaryOptions{
+		Mode: DictionaryMode(opts.String("mode", string(DictionaryUnigram))),
+	}
+	var err error
+	if res.IgnoreCase, err = opts.Bool("foldcase", true); err != nil {
+		return nil, err
+	}
+	if res.MaxEdits, err = opts.Int("maxedits", 0); err != nil {
+		return nil, err
+	} else if res.MaxEdits < 0 {
+		return nil, errors.New("maxedits cannot be negative")
+	}
+	if res.EditCost, err = opts.Float("editcost", dictionaryDefaultEditCost); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// apply sets the fuzzy matching options of a newly
+// created Dictionary.
+func (d *dictionaryOptions) apply(dict *Dictionary, err error) (Fielder, error) {
+	if err != nil {
+		return nil, err
+	}
+	dict.SetMaxEdits(d.MaxEdits)
+	dict.EditCost = d.EditCost
+	return dict, nil
+}
+
+func parseForestOptions(opts Options) (*ForestConfig, error) {
+	err := opts.Check("features", "before", "after", "seed", "trees", "samples", "attrs",
+		"balanced", "cutoff", "heldout")
+	if err != nil {
+		return nil, err
+	}
+	before, err := opts.Int("before", forestDefaultBefore)
+	if err != nil {
+		return nil, err
+	}
+	after, err := opts.Int("after", forestDefaultAfter)
+	if err != nil {
+		return nil, err
+	}
+	res := &ForestConfig{}
+	res.Features, err = ParseForestFeatures(opts.String("features",
+		"bytes,classes,transitions,words"), before, after)
+	if err != nil {
+		return nil, err
+	}
+	intOpts := []struct {
+		key string
+		ptr *int
+		def int
+	}{
+		{"trees", &res.NumTrees, forestDefaultTrees},
+		{"samples", &res.NumSamples, forestDefaultSamples},
+		{"attrs", &res.NumAttrs, 0},
+	}
+	for _, opt := range intOpts {
+		if *opt.ptr, err = opts.Int(opt.key, opt.def); err != nil {
+			return nil, err
+		} else if *opt.ptr < 0 {
+			return nil, errors.New(opt.key + " cannot be negative")
+		}
+	}
+	seed, err := opts.Int("seed", 0)
+	if err != nil {
+		return nil, err
+	}
+	res.Seed = int64(seed)
+	balanced, err := opts.Bool("balanced", true)
+	if err != nil {
+		return nil, err
+	}
+	res.Unbalanced = !balanced
+	if res.Cutoff, err = opts.Float("cutoff", 0); err != nil {
+		return nil, err
+	}
+	if res.HeldOut, err = opts.Float("heldout", 0); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// rnnNetworkOptions are the options of the "rnn" trainer
+// which configure a new network, and which cannot be
+// changed when an existing network is updated.
+var rnnNetworkOptions = []string{"runes", "hashbuckets", "cell", "layers", "state", "hidden",
+	"dropout"}
+
+func parseRNNOptions(opts Options) (*RNNConfig, error) {
+	res, err := parseRNNTrainingOptions(opts, rnnNetworkOptions...)
+	if err != nil {
+		return nil, err
+	}
+	arch := DefaultRNNArchitecture()
+	arch.Cell = opts.String("cell", arch.Cell)
+	if arch.Layers, err = opts.Int("layers", arch.Layers); err != nil {
+		return nil, err
+	}
+	if arch.StateSize, err = opts.Int("state", arch.StateSize); err != nil {
+		return nil, err
+	}
+	if _, ok := opts["hidden"]; ok {
+		if arch.OutputHidden, err = ParseRNNHidden(opts["hidden"]); err != nil {
+			return nil, err
+		}
+	}
+	if arch.Dropout, err = opts.Float("dropout", arch.Dropout); err != nil {
+		return nil, err
+	}
+	if err := arch.validate(); err != nil {
+		return nil, err
+	}
+	res.Architecture = arch
+	if res.MaxRunes, err = opts.Int("runes", rnnDefaultMaxRunes); err != nil {
+		return nil, err
+	}
+	if res.HashBuckets, err = opts.Int("hashbuckets", rnnDefaultHashBuckets); err != nil {
+		return nil, err
+	}
+	if res.MaxRunes < 0 || res.HashBuckets < 0 {
+		return nil, errors.New("rune encoding sizes cannot be negative")
+	}
+	return res, nil
+}
+
+// parseRNNUpdateOptions parses the options used to
+// continue training an existing RNN, which may only
+// change how it is trained.
+func parseRNNUpdateOptions(opts Options) (*RNNConfig, error) {
+	for _, key := range rnnNetworkOptions {
+		if _, ok := opts[key]; ok {
+			return nil, errors.New("option " + key + " cannot be changed for an existing RNN")
+		}
+	}
+	return parseRNNTrainingOptions(opts)
+}
+
+// parseRNNTrainingOptions parses the options which
+// control how an RNN is trained.
+func parseRNNTrainingOptions(opts Options, extraKeys ...string) (*RNNConfig, error) {
+	keys := append([]string{"validation", "maxepochs", "patience", "checkpoint",
+		"checkpointevery", "stepsize"}, extraKeys...)
+	if err := opts.Check(keys...); err != nil {
+		return nil, err
+	}
+	res := &RNNConfig{Checkpoint: opts.String("checkpoint", "")}
+	var err error
+	if res.Validation, err = opts.Float("validation", rnnDefaultValidation); err != nil {
+		return nil, err
+	}
+	if res.StepSize, err = opts.Float("stepsize", rnnStepSize); err != nil {
+		return nil, err
+	}
+	intOpts := []struct {
+		key string
+		ptr *int
+		def int
+	}{
+		{"maxepochs", &res.MaxEpochs, 0},
+		{"patience", &res.Patience, rnnDefaultPatience},
+		{"checkpointevery", &res.CheckpointInterval, 1},
+	}
+	for _, opt := range intOpts {
+		if *opt.ptr, err = opts.Int(opt.key, opt.def); err != nil {
+			return nil, err
+		} else if *opt.ptr < 0 {
+			return nil, errors.New(opt.key + " cannot be negative")
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,126 @@
+package spacesplice
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	tokenJoiners       = "'’-."
+	openingPunctuation = "([{“‘«¿¡"
+	closingPunctuation = ".,;:!?)]}%…”’»"
+)
+
+var contractionSuffixes = []string{"s", "t", "d", "m", "re", "ve", "ll"}
+
+// Tokenize splits a body of text into the tokens used
+// for training.
+//
+// Text is split on whitespace, and punctuation is then
+// split off into separate tokens.
+// Apostrophes, hyphens, and periods between two letters
+// or digits do not split a word, so contractions like
+// "it's", compounds like "super-human", and abbreviations
+// like "e.g" remain single tokens.
+func Tokenize(text string) []string {
+	var res []string
+	for _, field := range strings.Fields(text) {
+		res = append(res, tokenizeField(field)...)
+	}
+	return res
+}
+
+func tokenizeField(field string) []string {
+	var res []string
+	var wordStart int
+	for i := 0; i < len(field); {
+		// Invalid bytes decode as utf8.RuneError with a
+		// size of 1, so each becomes its own token.
+		r, size := utf8.DecodeRuneInString(field[i:])
+		end := i + size
+		if isWordRune(r) {
+			i = end
+			continue
+		}
+		if strings.ContainsRune(tokenJoiners, r) && i > wordStart {
+			next, _ := utf8.DecodeRuneInString(field[end:])
+			if isWordRune(next) {
+				i = end
+				continue
+			}
+		}
+		if i > wordStart {
+			res = append(res, field[wordStart:i])
+		}
+		res = append(res, field[i:end])
+		wordStart, i = end, end
+	}
+	if wordStart < len(field) {
+		res = append(res, field[wordStart:])
+	}
+	return res
+}
+
+// joinPunctuation merges fields so that there is never a
+// space before closing punctuation, after opening
+// punctuation, inside a contraction, or around the hyphen
+// of a compound word.
+//
+// A lone "'" may open or close a quotation, so the quotes
+// are paired up: the first of each pair is joined to the
+// field after it and the second to the field before it.
+func joinPunctuation(fields []string) []string {
+	var res []string
+	var quoted, opened bool
+	for _, field := range fields {
+		join := len(res) > 0 && (opened || joinsPrevious(res[len(res)-1], field))
+		opened = false
+		if field == "'" {
+			if quoted {
+				join = len(res) > 0
+			} else {
+				opened = true
+			}
+			quoted = !quoted
+		}
+		if join {
+			res[len(res)-1] += field
+		} else {
+			res = append(res, field)
+		}
+	}
+	return res
+}
+
+func joinsPrevious(previous, field string) bool {
+	last, _ := utf8.DecodeLastRuneInString(previous)
+	if last == '-' || strings.ContainsRune(openingPunctuation, last) {
+		return true
+	}
+	first, size := utf8.DecodeRuneInString(field)
+	if first == '-' {
+		return true
+	}
+	if first == '\'' || first == '’' {
+		suffix := strings.ToLower(field[size:])
+		for _, x := range contractionSuffixes {
+			if suffix == x {
+				return true
+			}
+		}
+	}
+	if lower := strings.ToLower(field); lower == "n't" || lower == "n’t" {
+		return true
+	}
+	for _, r := range field {
+		if !strings.ContainsRune(closingPunctuation, r) {
+			return false
+		}
+	}
+	return true
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r)
+}
@@ -0,0 +1,51 @@
+package spacesplice
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	cases := map[string][]string{
+		"Hello, world!":             {"Hello", ",", "world", "!"},
+		"it's a super-human (test)": {"it's", "a", "super-human", "(", "test", ")"},
+		"e.g. this…":                {"e.g", ".", "this", "…"},
+		"naïve café.":               {"naïve", "café", "."},
+		"ab\xff":                    {"ab", "\xff"},
+		"ab\xffcd":                  {"ab", "\xff", "cd"},
+		"\xe2\x80":                  {"\xe2", "\x80"},
+		"x-\xff":                    {"x", "-", "\xff"},
+	}
+	for input, expected := range cases {
+		actual := Tokenize(input)
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("Tokenize(%q): expected %q but got %q", input, expected, actual)
+		}
+	}
+}
+
+func TestJoinPunctuation(t *testing.T) {
+	cases := [][2][]string{
+		{
+			{"(", "it", "'s", "a", "super", "-", "human", "test", ")", "."},
+			{"(it's", "a", "super-human", "test)."},
+		},
+		{
+			{"she", "said", "'", "hello", "'", "."},
+			{"she", "said", "'hello'."},
+		},
+		{
+			{"'", "it", "'s", "'", "and", "'", "they", "'re", "'"},
+			{"'it's'", "and", "'they're'"},
+		},
+		{
+			{"(", "'", "quoted", "'", ")"},
+			{"('quoted')"},
+		},
+	}
+	for _, c := range cases {
+		if actual := joinPunctuation(c[0]); !reflect.DeepEqual(actual, c[1]) {
+			t.Errorf("joinPunctuation(%q): expected %q but got %q", c[0], c[1], actual)
+		}
+	}
+}
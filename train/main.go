@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -12,19 +13,35 @@ import (
 )
 
 func main() {
-	if len(os.Args) != 4 {
-		fmt.Fprintln(os.Stderr, "Usage: train <model> <corpus dir> <output file>")
+	var optionList optionFlag
+	flag.Var(&optionList, "o", "model-specific `key=value` option (may be repeated)")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: train [flags] <model> <corpus> <output file>")
+		flag.PrintDefaults()
 		printModels()
+		fmt.Fprintln(os.Stderr, "The corpus is a directory of text files, or a word list "+
+			"file for wordlist, freqlist, and hunspell.")
+	}
+	flag.Parse()
+
+	if flag.NArg() != 3 {
+		flag.Usage()
 		os.Exit(1)
 	}
 
-	trainer, ok := spacesplice.Trainers[os.Args[1]]
+	trainer, ok := spacesplice.Trainers[flag.Arg(0)]
 	if !ok {
-		fmt.Fprintln(os.Stderr, "Unknown model:", os.Args[1])
+		fmt.Fprintln(os.Stderr, "Unknown model:", flag.Arg(0))
+		os.Exit(1)
+	}
+
+	opts, err := spacesplice.ParseOptions(optionList)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 
-	res, err := trainer(os.Args[2])
+	res, err := trainer(flag.Arg(1), opts)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "Error training model:", err)
 		os.Exit(1)
@@ -36,7 +53,7 @@ func main() {
 		os.Exit(1)
 	}
 
-	if err := ioutil.WriteFile(os.Args[3], serialized, 0755); err != nil {
+	if err := ioutil.WriteFile(flag.Arg(2), serialized, 0755); err != nil {
 		fmt.Fprintln(os.Stderr, "Failed to save:", err)
 		os.Exit(1)
 	}
@@ -50,3 +67,14 @@ func printModels() {
 	sort.Strings(names)
 	fmt.Fprintln(os.Stderr, "Available models:", strings.Join(names, ", "))
 }
+
+type optionFlag []string
+
+func (o *optionFlag) String() string {
+	return strings.Join(*o, ",")
+}
+
+func (o *optionFlag) Set(s string) error {
+	*o = append(*o, s)
+	return nil
+}
@@ -0,0 +1,85 @@
+package spacesplice
+
+// A trie is a prefix tree of words.
+// It can enumerate every word which starts a string in a
+// single pass over the string.
+type trie struct {
+	children map[byte]*trie
+
+	// index is one more than the index of the word which
+	// ends at this node, or 0 if no word ends here.
+	index int
+}
+
+// newTrie creates a trie containing the given words.
+// Each word is associated with its index in the list.
+func newTrie(words []string) *trie {
+	res := &trie{}
+	for i, word := range words {
+		res.insert(word, i)
+	}
+	return res
+}
+
+// insert adds a word to the trie.
+func (t *trie) insert(word string, index int) {
+	node := t
+	for i := 0; i < len(word); i++ {
+		if node.children == nil {
+			node.children = map[byte]*trie{}
+		}
+		child := node.children[word[i]]
+		if child == nil {
+			child = &trie{}
+			node.children[word[i]] = child
+		}
+		node = child
+	}
+	node.index = index + 1
+}
+
+// contains returns true if the word is in the trie.
+func (t *trie) contains(word string) bool {
+	_, ok := t.lookup(word)
+	return ok
+}
+
+// lookup returns the index of a word in the trie.
+func (t *trie) lookup(word string) (index int, ok bool) {
+	node := t
+	for i := 0; i < len(word) && node != nil; i++ {
+		node = node.children[word[i]]
+	}
+	if node == nil || node.index == 0 {
+		return 0, false
+	}
+	return node.index - 1, true
+}
+
+// prefixes calls f with every non-empty word in the trie
+// which is a prefix of str, from shortest to longest.
+func (t *trie) prefixes(str string, f func(word string)) {
+	node := t
+	for i := 0; i < len(str); i++ {
+		node = node.children[str[i]]
+		if node == nil {
+			return
+		}
+		if node.index != 0 {
+			f(str[:i+1])
+		}
+	}
+}
+
+// followingCandidates enumerates the candidate words at
+// the start of str: every prefix of up to maxWordLen
+// bytes, followed by any longer prefixes which are words
+// in the trie.
+func followingCandidates(t *trie, str string, f func(word string)) {
+	followingWords(str, f)
+	t.prefixes(str, func(word string) {
+		if len(word) > maxWordLen {
+			f(word)
+		}
+	})
+}
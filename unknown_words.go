@@ -0,0 +1,159 @@
+package spacesplice
+
+import (
+	"math"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	unknownWordsOrder    = 4
+	unknownWordsMaxLen   = 30
+	unknownWordsAlphabet = 256
+	unknownWordsDiscount = 0.75
+	unknownWordsPad      = "\x00"
+)
+
+// UnknownWords is a character-level model of words which
+// are missing from a model's vocabulary, such as names,
+// rare nouns, and technical terms.
+//
+// It scores a word by the probability of its length and
+// the probability of each of its characters given the
+// characters before it.
+type UnknownWords struct {
+	// Order is the number of characters in each character
+	// n-gram, so each character is conditioned on the
+	// Order-1 characters before it.
+	Order int
+
+	// Counts maps character contexts of every length up
+	// to Order-1 to the number of times each character
+	// followed them.
+	// Contexts at the start of a word are padded with
+	// null characters.
+	Counts map[string]map[string]int
+
+	// Lengths maps each word length (in characters) to
+	// the number of words with that length.
+	// The last entry counts every longer word.
+	Lengths []int
+
+	// totals stores the total count of each context.
+	// It is computed when the model is created or decoded,
+	// since Prob may be called from multiple Goroutines.
+	totals map[string]int
+}
+
+// NewUnknownWords trains an UnknownWords model on the
+// words of a vocabulary.
+// Each word should be listed once, since unknown words
+// look more like rare words than like common ones.
+func NewUnknownWords(vocab []string) *UnknownWords {
+	res := &UnknownWords{
+		Order:   unknownWordsOrder,
+		Counts:  map[string]map[string]int{},
+		Lengths: make([]int, unknownWordsMaxLen+1),
+	}
+	for _, word := range vocab {
+		res.addWord(word)
+	}
+	res.computeTotals()
+	return res
+}
+
+// Prob returns the probability of a word under the
+// model.
+func (u *UnknownWords) Prob(word string) float64 {
+	var history []string
+	for i := 1; i < u.Order; i++ {
+		history = append(history, unknownWordsPad)
+	}
+	res := u.lengthProb(utf8.RuneCountInString(word))
+	for _, r := range word {
+		ch := string(r)
+		res *= u.charProb(history, ch)
+		if len(history) > 0 {
+			history = append(history[1:], ch)
+		}
+	}
+	return res
+}
+
+func (u *UnknownWords) addWord(word string) {
+	var history []string
+	for i := 1; i < u.Order; i++ {
+		history = append(history, unknownWordsPad)
+	}
+	var length int
+	for _, r := range word {
+		ch := string(r)
+		for i := 0; i <= len(history); i++ {
+			context := strings.Join(history[len(history)-i:], "")
+			subTable := u.Counts[context]
+			if subTable == nil {
+				subTable = map[string]int{}
+				u.Counts[context] = subTable
+			}
+			subTable[ch]++
+		}
+		if len(history) > 0 {
+			history = append(history[1:], ch)
+		}
+		length++
+	}
+	if length >= len(u.Lengths) {
+		length = len(u.Lengths) - 1
+	}
+	u.Lengths[length]++
+}
+
+// computeTotals computes u.totals from u.Counts.
+// It must be called after an UnknownWords is decoded.
+func (u *UnknownWords) computeTotals() {
+	u.totals = map[string]int{}
+	for context, subTable := range u.Counts {
+		for _, count := range subTable {
+			u.totals[context] += count
+		}
+	}
+}
+
+// charProb computes the probability of a character with
+// interpolated absolute discounting, backing off to a
+// uniform distribution.
+func (u *UnknownWords) charProb(history []string, ch string) float64 {
+	res := 1.0 / unknownWordsAlphabet
+	for i := 0; i <= len(history); i++ {
+		context := strings.Join(history[len(history)-i:], "")
+		total := u.totals[context]
+		if total == 0 {
+			continue
+		}
+		subTable := u.Counts[context]
+		discounted := math.Max(float64(subTable[ch])-unknownWordsDiscount, 0)
+		backoffMass := unknownWordsDiscount * float64(len(subTable))
+		res = (discounted + backoffMass*res) / float64(total)
+	}
+	return res
+}
+
+// lengthProb computes the add-one smoothed probability
+// of a word length.
+// Lengths past the last entry of u.Lengths split that
+// entry's probability geometrically.
+func (u *UnknownWords) lengthProb(length int) float64 {
+	if len(u.Lengths) == 0 {
+		return markovUnknownStop * math.Pow(1-markovUnknownStop, float64(length-1))
+	}
+	var total int
+	for _, count := range u.Lengths {
+		total += count + 1
+	}
+	last := len(u.Lengths) - 1
+	if length < last {
+		return float64(u.Lengths[length]+1) / float64(total)
+	}
+	tailProb := float64(u.Lengths[last]+1) / float64(total)
+	return tailProb * math.Pow(0.5, float64(length-last+1))
+}
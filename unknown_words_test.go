@@ -0,0 +1,33 @@
+package spacesplice
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestUnknownWordsDecoded(t *testing.T) {
+	n, err := TrainNGram(testCorpusDir(t), 3, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := n.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte(`"Unknown"`)) {
+		t.Error("the model of unseen words was serialized")
+	}
+	decoded, err := DeserializeNGram(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Unknown.totals == nil {
+		t.Fatal("totals were not computed when decoding")
+	}
+	for _, word := range []string{"documents", "xqzt", "splitting"} {
+		expected := n.Unknown.Prob(word)
+		if actual := decoded.Unknown.Prob(word); actual != expected {
+			t.Errorf("word %q: expected probability %v but got %v", word, expected, actual)
+		}
+	}
+}
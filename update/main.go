@@ -0,0 +1,116 @@
+// Command update trains an existing model further on a
+// corpus directory, or merges two Markov models.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/unixpickle/serializer"
+	"github.com/unixpickle/spacesplice"
+)
+
+func main() {
+	var optionList optionFlag
+	flag.Var(&optionList, "o", "model-specific `key=value` training option (may be repeated)")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: update [flags] <model file> <corpus dir | model file> <output file>")
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintln(os.Stderr, "If the second argument is a directory, the model is trained")
+		fmt.Fprintln(os.Stderr, "further on it. Otherwise, it must be a second model to merge")
+		fmt.Fprintln(os.Stderr, "into the first one.")
+		fmt.Fprintln(os.Stderr)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 3 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	opts, err := spacesplice.ParseOptions(optionList)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	model := readModel(flag.Arg(0))
+
+	if info, err := os.Stat(flag.Arg(1)); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	} else if info.IsDir() {
+		if err := update(model, flag.Arg(1), opts); err != nil {
+			fmt.Fprintln(os.Stderr, "Error updating model:", err)
+			os.Exit(1)
+		}
+	} else {
+		if len(opts) > 0 {
+			fmt.Fprintln(os.Stderr, "Options are not supported when merging models.")
+			os.Exit(1)
+		}
+		m1, ok1 := model.(*spacesplice.Markov)
+		m2, ok2 := readModel(flag.Arg(1)).(*spacesplice.Markov)
+		if !ok1 || !ok2 {
+			fmt.Fprintln(os.Stderr, "Only Markov models can be merged.")
+			os.Exit(1)
+		}
+		if err := m1.Merge(m2); err != nil {
+			fmt.Fprintln(os.Stderr, "Error merging models:", err)
+			os.Exit(1)
+		}
+	}
+
+	serialized, err := serializer.SerializeWithType(model)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to serialize:", err)
+		os.Exit(1)
+	}
+	if err := ioutil.WriteFile(flag.Arg(2), serialized, 0755); err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to save:", err)
+		os.Exit(1)
+	}
+}
+
+func readModel(path string) serializer.Serializer {
+	modelData, err := ioutil.ReadFile(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to read model:", err)
+		os.Exit(1)
+	}
+	model, err := serializer.DeserializeWithType(modelData)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to deserialize model:", err)
+		os.Exit(1)
+	}
+	return model
+}
+
+func update(model serializer.Serializer, corpusDir string, opts spacesplice.Options) error {
+	if updater, ok := model.(spacesplice.OptionsUpdater); ok {
+		return updater.UpdateWithOptions(corpusDir, opts)
+	}
+	updater, ok := model.(spacesplice.Updater)
+	if !ok {
+		return fmt.Errorf("model cannot be updated: %T", model)
+	}
+	if len(opts) > 0 {
+		return fmt.Errorf("model does not support options: %T", model)
+	}
+	return updater.Update(corpusDir)
+}
+
+type optionFlag []string
+
+func (o *optionFlag) String() string {
+	return strings.Join(*o, ",")
+}
+
+func (o *optionFlag) Set(s string) error {
+	*o = append(*o, s)
+	return nil
+}
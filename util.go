@@ -1,9 +1,12 @@
 package spacesplice
 
 import (
+	"bytes"
 	"io/ioutil"
 	"path/filepath"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 func ReadSamples(dir string, f func(d []byte)) error {
@@ -25,3 +28,36 @@ func ReadSamples(dir string, f func(d []byte)) error {
 	}
 	return nil
 }
+
+// foldCase converts s to lower case for case-insensitive
+// lookups.
+// Characters whose lower-case form has a different UTF-8
+// length are left alone, so that byte offsets into the
+// result are also valid offsets into s.
+func foldCase(s string) string {
+	var res bytes.Buffer
+	for len(s) > 0 {
+		r, size := utf8.DecodeRuneInString(s)
+		lower := unicode.ToLower(r)
+		if r != utf8.RuneError && utf8.RuneLen(lower) == size {
+			res.WriteRune(lower)
+		} else {
+			res.WriteString(s[:size])
+		}
+		s = s[size:]
+	}
+	return res.String()
+}
+
+// splitLike splits s into fields with the same lengths
+// as the given fields.
+// This maps fields of a case-folded string back onto the
+// original string.
+func splitLike(s string, fields []string) []string {
+	res := make([]string, len(fields))
+	for i, field := range fields {
+		res[i] = s[:len(field)]
+		s = s[len(field):]
+	}
+	return res
+}
@@ -0,0 +1,40 @@
+package spacesplice
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// testCorpus is a small corpus used to train models in
+// tests.
+var testCorpus = []string{
+	"The documentation consists of two parts. The first part describes the " +
+		"model, and the second part describes the training data.",
+	"Both parts of the documentation are short. The model splits text into words.",
+	"A word is a sequence of letters. The training data consists of text files.",
+}
+
+// TestMain hides the progress which is logged while
+// training models.
+func TestMain(m *testing.M) {
+	log.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+// testCorpusDir writes testCorpus and any extra samples
+// to a temporary directory, with one file per sample.
+// The directory is removed when the test finishes.
+func testCorpusDir(t *testing.T, extra ...string) string {
+	dir := t.TempDir()
+	for i, sample := range append(append([]string{}, testCorpus...), extra...) {
+		path := filepath.Join(dir, strconv.Itoa(i)+".txt")
+		if err := ioutil.WriteFile(path, []byte(sample), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
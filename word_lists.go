@@ -0,0 +1,68 @@
+package spacesplice
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// LoadWordList creates a Dictionary from a file which
+// lists one word per line.
+// Every word is given a count of 1.
+// ignoreCase works like it does for TrainDictionary.
+func LoadWordList(path string, mode DictionaryMode, ignoreCase bool) (*Dictionary, error) {
+	if !mode.valid() {
+		return nil, errors.New("unknown dictionary mode: " + string(mode))
+	}
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	counts := map[string]int{}
+	for _, word := range strings.Fields(string(contents)) {
+		if ignoreCase {
+			word = foldCase(word)
+		}
+		counts[word] = 1
+	}
+	return NewDictionary(counts, mode), nil
+}
+
+// LoadFrequencyList creates a Dictionary from a file
+// where each line contains a word, a tab, and the number
+// of times the word was seen.
+// ignoreCase works like it does for TrainDictionary, and
+// the counts of words which only differ in case are
+// added together.
+func LoadFrequencyList(path string, mode DictionaryMode, ignoreCase bool) (*Dictionary, error) {
+	if !mode.valid() {
+		return nil, errors.New("unknown dictionary mode: " + string(mode))
+	}
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	counts := map[string]int{}
+	for i, line := range strings.Split(string(contents), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		tabIdx := strings.LastIndex(line, "\t")
+		if tabIdx < 0 {
+			return nil, fmt.Errorf("line %d: missing tab", i+1)
+		}
+		word := strings.TrimSpace(line[:tabIdx])
+		count, err := strconv.Atoi(strings.TrimSpace(line[tabIdx+1:]))
+		if err != nil || count < 0 {
+			return nil, fmt.Errorf("line %d: invalid count", i+1)
+		}
+		if ignoreCase {
+			word = foldCase(word)
+		}
+		counts[word] += count
+	}
+	return NewDictionary(counts, mode), nil
+}
@@ -0,0 +1,109 @@
+package spacesplice
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadWordList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "words.txt")
+	if err := ioutil.WriteFile(path, []byte("the\nCat\n\n  sat \r\nthe\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[bool][]string{
+		false: {"Cat", "sat", "the"},
+		true:  {"cat", "sat", "the"},
+	}
+	for ignoreCase, words := range expected {
+		d, err := LoadWordList(path, DictionaryUnigram, ignoreCase)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(d.Words, words) || !reflect.DeepEqual(d.Counts, []int{1, 1, 1}) {
+			t.Errorf("ignoreCase=%v: expected %q but got %q with counts %v", ignoreCase,
+				words, d.Words, d.Counts)
+		}
+	}
+}
+
+func TestLoadFrequencyList(t *testing.T) {
+	cases := []struct {
+		name       string
+		contents   string
+		ignoreCase bool
+		words      []string
+		counts     []int
+	}{
+		{
+			name:     "basic",
+			contents: "the\t10\ncat\t3\n",
+			words:    []string{"cat", "the"},
+			counts:   []int{3, 10},
+		},
+		{
+			name:     "blank lines and carriage returns",
+			contents: "the\t10\r\n\r\n   \ncat\t3\r\n",
+			words:    []string{"cat", "the"},
+			counts:   []int{3, 10},
+		},
+		{
+			name:     "spaces around fields",
+			contents: "  new york \t 7 \n",
+			words:    []string{"new york"},
+			counts:   []int{7},
+		},
+		{
+			name:     "case sensitive",
+			contents: "The\t5\nthe\t10\n",
+			words:    []string{"The", "the"},
+			counts:   []int{5, 10},
+		},
+		{
+			name:       "case folded",
+			contents:   "The\t5\nthe\t10\n",
+			ignoreCase: true,
+			words:      []string{"the"},
+			counts:     []int{15},
+		},
+		{
+			name:     "last tab separates the count",
+			contents: "a\tb\t2\n",
+			words:    []string{"a\tb"},
+			counts:   []int{2},
+		},
+	}
+	dir := t.TempDir()
+	for i, c := range cases {
+		path := filepath.Join(dir, "valid"+string(rune('a'+i))+".txt")
+		if err := ioutil.WriteFile(path, []byte(c.contents), 0755); err != nil {
+			t.Fatal(err)
+		}
+		d, err := LoadFrequencyList(path, DictionaryUnigram, c.ignoreCase)
+		if err != nil {
+			t.Errorf("%s: %s", c.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(d.Words, c.words) || !reflect.DeepEqual(d.Counts, c.counts) {
+			t.Errorf("%s: expected %q with counts %v but got %q with counts %v", c.name,
+				c.words, c.counts, d.Words, d.Counts)
+		}
+	}
+
+	malformed := map[string]string{
+		"missing tab":    "the\t10\nthe 10\n",
+		"missing count":  "the\t\n",
+		"invalid count":  "the\tten\n",
+		"negative count": "the\t-1\n",
+	}
+	for name, contents := range malformed {
+		path := filepath.Join(dir, "malformed.txt")
+		if err := ioutil.WriteFile(path, []byte(contents), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadFrequencyList(path, DictionaryUnigram, false); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}